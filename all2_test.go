@@ -5,15 +5,25 @@
 package regexp
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"path"
+	"reflect"
 	"regexp"
 	"runtime"
 	"sort"
 	"strings"
 	"testing"
+	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 func caller(s string, va ...interface{}) {
@@ -52,10 +62,21 @@ func init() {
 	use(caller, dbg, TODO) //TODOOK
 }
 
+// mustCompile is like MustCompile but specializes the result if -specialize
+// is set.
+func mustCompile(expr string) *Regexp {
+	re := MustCompile(expr)
+	if *oSpecialize {
+		re = re.Specialize()
+	}
+	return re
+}
+
 // ============================================================================
 
 var (
-	oCase = flag.Int("case", -1, "")
+	oCase       = flag.Int("case", -1, "")
+	oSpecialize = flag.Bool("specialize", false, "specialize all compiled regexps")
 
 	goodRe2 = []string{
 		`()`,
@@ -725,33 +746,6 @@ func (re *Regexp) str(start int) string {
 	return strings.Join(sa, "\n")
 }
 
-func (re *Regexp) checkInvariants(s int) {
-	for _, state := range re.reachable(s, -1) {
-		ps := &re.prog[state]
-		switch ps.kind {
-		case
-			opAccept,
-			opAssert,
-			opChar,
-			opCharClass,
-			opDot,
-			opDotNL,
-			opNotCharClass,
-			opSave,
-			opSplit:
-			// nop
-		case opNop:
-			if noOpt {
-				break
-			}
-
-			panic("internal error")
-		default:
-			panic(ps.kind)
-		}
-	}
-}
-
 func (re *Regexp) fullMatch(s string) bool {
 	return newVM(re, strings.NewReader(s)).fullMatch()
 }
@@ -786,7 +780,10 @@ func TestFullMatch(t *testing.T) {
 			return
 		}
 
-		re.checkInvariants(re.start)
+		if err := re.checkInvariants(re.start); err != nil {
+			t.Fatal(i, err)
+		}
+
 		if o >= 0 {
 			t.Logf("[%d]\n`%s` `%s`\n%s", i, s, v.src, re.str(re.start))
 		}
@@ -826,7 +823,10 @@ func TestMatchString(t *testing.T) {
 			return
 		}
 
-		re.checkInvariants(re.start1)
+		if err := re.checkInvariants(re.start1); err != nil {
+			t.Fatal(i, err)
+		}
+
 		if o >= 0 {
 			t.Logf("[%d]\n`%s` `%s`\n%s", i, s, v.src, re.str(re.start1))
 		}
@@ -842,6 +842,28 @@ func TestMatchString(t *testing.T) {
 	}
 }
 
+func TestFindPastNewline(t *testing.T) {
+	for i, v := range []struct {
+		re, src string
+		exp     []int
+	}{
+		{`b`, "a\nb", []int{2, 3}},
+		{`b`, "\n\nb\n", []int{2, 3}},
+		{`a.b`, "a\nb axb", []int{4, 7}},
+		{`(?s)a.b`, "x\na\nb", []int{2, 5}},
+		{`x`, "a\nb", nil},
+	} {
+		re := MustCompile(v.re)
+		if g, e := fmt.Sprint(re.FindStringIndex(v.src)), fmt.Sprint(v.exp); g != e {
+			t.Errorf("#%d: `%s` %q: got %v exp %v", i, v.re, v.src, g, e)
+		}
+
+		if g, e := re.MatchString(v.src), v.exp != nil; g != e {
+			t.Errorf("#%d: `%s` %q: got %v exp %v", i, v.re, v.src, g, e)
+		}
+	}
+}
+
 func BenchmarkCompileSimple(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		for _, v := range simpleTests {
@@ -991,6 +1013,57 @@ func BenchmarkMatchStringSimpleNew(b *testing.B) {
 	}
 }
 
+func BenchmarkMatchReaderSimple(b *testing.B) {
+	a := make([]*regexp.Regexp, len(simpleTests))
+	for i, v := range simpleTests {
+		var err error
+		if a[i], err = regexp.Compile(v.re); err != nil {
+			b.Fatal(err)
+			return
+		}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for i, v := range simpleTests {
+			a[i].MatchReader(strings.NewReader(v.src))
+		}
+	}
+}
+
+func BenchmarkMatchReaderSimplePOSIX(b *testing.B) {
+	a := make([]*regexp.Regexp, len(simpleTests))
+	for i, v := range simpleTests {
+		var err error
+		if a[i], err = regexp.CompilePOSIX(v.re); err != nil {
+			b.Fatal(err)
+			return
+		}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for i, v := range simpleTests {
+			a[i].MatchReader(strings.NewReader(v.src))
+		}
+	}
+}
+
+func BenchmarkMatchReaderSimpleNew(b *testing.B) {
+	a := make([]*Regexp, len(simpleTests))
+	for i, v := range simpleTests {
+		var err error
+		if a[i], err = Compile(v.re); err != nil {
+			b.Fatal(err)
+			return
+		}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for i, v := range simpleTests {
+			a[i].MatchReader(strings.NewReader(v.src))
+		}
+	}
+}
+
 type regexper interface {
 	MatchString(string) bool
 }
@@ -1044,3 +1117,1915 @@ func BenchmarkCountPOSIX_256_1e5(b *testing.B) {
 func BenchmarkCountNew_256_1e5(b *testing.B) {
 	benchmarkCountNew(b, fmt.Sprintf(benchmarkCountRe, 256))
 }
+
+func TestLiteralPrefixes(t *testing.T) {
+	for i, v := range []struct {
+		re  string
+		exp []string
+	}{
+		{``, nil},
+		{`.*a`, nil},
+		{`^abc`, nil},
+		{`a|`, nil},
+		{`a*b`, []string{"a", "b"}},
+		{`abc`, []string{"abc"}},
+		{`ab|cd`, []string{"ab", "cd"}},
+		{`ab|abc`, []string{"ab"}},
+		{`(GET|POST|PUT) /`, []string{"GET /", "POST /", "PUT /"}},
+		{`(a|b)(c|d)`, []string{"ac", "ad", "bc", "bd"}},
+		{`(ab)+x`, []string{"ab"}},
+		{`a[bc]`, []string{"a"}},
+		{`a|b.`, []string{"a", "b"}},
+		{`abcdefghijk|x`, []string{"abcdefgh", "x"}},
+		{`[ab]c`, nil},
+	} {
+		re := MustCompile(v.re)
+		if g, e := re.literalPrefixes(), v.exp; !reflect.DeepEqual(g, e) {
+			t.Errorf("%d: `%s`: got %q exp %q", i, v.re, g, e)
+		}
+	}
+}
+
+func TestPrefilterIndex(t *testing.T) {
+	lits := []string{"he", "she", "his", "hers", "日本", "ab", "b"}
+	for i := 1; i <= len(lits); i++ {
+		p := newPrefilter(lits[:i])
+		for _, s := range []string{
+			"",
+			"h",
+			"ushers",
+			"xhis",
+			"xxxxxhersxxxxxshe",
+			"ahishers",
+			"x日本",
+			"aab",
+			"cab",
+			"sheab",
+		} {
+			e := -1
+			for _, v := range lits[:i] {
+				if j := strings.Index(s, v); j >= 0 && (e < 0 || j < e) {
+					e = j
+				}
+			}
+			if g := p.index(s); g != e {
+				t.Errorf("%q %q: got %v exp %v", lits[:i], s, g, e)
+			}
+			if g := p.indexBytes([]byte(s)); g != e {
+				t.Errorf("%q %q: got %v exp %v (bytes)", lits[:i], s, g, e)
+			}
+		}
+	}
+}
+
+var prefilterTests = []struct {
+	re, src string
+}{
+	{`(GET|POST|PUT) /`, "GET /index.html"},
+	{`(GET|POST|PUT) /`, "10.0.0.1 - - POST /x\n10.0.0.2 - - PUT /y"},
+	{`(GET|POST|PUT) /`, "GETPOST / PUT"},
+	{`(GET|POST|PUT) /`, "HEAD /"},
+	{`ab|cd`, "xxabxcdxab"},
+	{`a*b`, "xaaabxbb"},
+	{`b`, "a\nb"},
+	{`foo`, "seafood foo"},
+	{`foo(.)`, "foo foox"},
+	{`(a)(b)|(c)`, "xxabcab"},
+	{`日本|本語`, "x日本語本語"},
+	{`hers|his|she|he`, "ushers his"},
+}
+
+func TestPrefilter(t *testing.T) {
+	for i, v := range prefilterTests {
+		re := MustCompile(v.re)
+		if re.prefilter == nil {
+			t.Errorf("%d: `%s`: no prefilter", i, v.re)
+			continue
+		}
+
+		re0 := re.Copy()
+		re0.prefilter = nil
+		re2 := regexp.MustCompile(v.re)
+		g := re.FindAllStringSubmatchIndex(v.src, -1)
+		if e := re0.FindAllStringSubmatchIndex(v.src, -1); !reflect.DeepEqual(g, e) {
+			t.Errorf("%d: `%s` %q: got %v exp %v", i, v.re, v.src, g, e)
+		}
+		if e := re2.FindAllStringSubmatchIndex(v.src, -1); !reflect.DeepEqual(g, e) {
+			t.Errorf("%d: `%s` %q: got %v exp %v (stdlib)", i, v.re, v.src, g, e)
+		}
+		if g := re.FindAllSubmatchIndex([]byte(v.src), -1); !reflect.DeepEqual(g, re2.FindAllSubmatchIndex([]byte(v.src), -1)) {
+			t.Errorf("%d: `%s` %q: got %v (bytes)", i, v.re, v.src, g)
+		}
+		if g, e := re.MatchString(v.src), re2.MatchString(v.src); g != e {
+			t.Errorf("%d: `%s` %q: got %v exp %v", i, v.re, v.src, g, e)
+		}
+		if g, e := re.ReplaceAllString(v.src, "<$0>"), re2.ReplaceAllString(v.src, "<$0>"); g != e {
+			t.Errorf("%d: `%s` %q: got %q exp %q", i, v.re, v.src, g, e)
+		}
+	}
+}
+
+const benchmarkAccessLogRe = `(GET|POST|PUT) /admin`
+
+var benchmarkAccessLog = strings.Repeat(`10.0.0.1 - - [18/Oct/2017:10:00:00 +0000] "HEAD /index.html HTTP/1.1" 200 512`+"\n", 1000) +
+	`10.0.0.2 - - [18/Oct/2017:10:00:01 +0000] "POST /admin HTTP/1.1" 403 0` + "\n"
+
+func benchmarkAccessLog0(b *testing.B, re regexper) {
+	b.SetBytes(int64(len(benchmarkAccessLog)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if !re.MatchString(benchmarkAccessLog) {
+			b.Fatal()
+		}
+	}
+}
+
+func BenchmarkAccessLog(b *testing.B) {
+	benchmarkAccessLog0(b, regexp.MustCompile(benchmarkAccessLogRe))
+}
+
+func BenchmarkAccessLogNew(b *testing.B) {
+	benchmarkAccessLog0(b, MustCompile(benchmarkAccessLogRe))
+}
+
+func TestInnerLiterals(t *testing.T) {
+	for i, v := range []struct {
+		re     string
+		lits   []string
+		before []int
+	}{
+		{`abc`, []string{"abc"}, []int{0}},
+		{`[a-z]+@example\.com`, []string{"@example.com"}, []int{-1}},
+		{`.*ERROR.*timeout`, []string{"timeout", "ERROR"}, []int{-1, -1}},
+		{`[a-z]{3}@x`, []string{"@x"}, []int{3}},
+		{`[ab](cd|ef)gh`, []string{"gh"}, []int{3}},
+		{`[ab](cd|ce)`, nil, nil},
+		{`[ab]|c`, nil, nil},
+		{`[ab]*`, nil, nil},
+		{`x(abc)+y`, []string{"xabc", "y"}, []int{0, -1}},
+	} {
+		re := MustCompile(v.re)
+		lits, before := re.innerLiterals()
+		if !reflect.DeepEqual(lits, v.lits) || !reflect.DeepEqual(before, v.before) {
+			t.Errorf("%d: `%s`: got %q %v exp %q %v", i, v.re, lits, before, v.lits, v.before)
+		}
+	}
+}
+
+var innerTests = []struct {
+	re, src string
+}{
+	{`[a-z]+@example\.com`, "mail jdoe@example.com, root@example.org and x@example.com"},
+	{`[a-z]+@example\.com`, "nothing to see here"},
+	{`[a-z]{3}@x`, "ab@x abc@x abcd@x 日本@x"},
+	{`.*ERROR.*timeout`, "ok\nERROR: read timeout\nERROR: eof"},
+	{`.*ERROR.*timeout`, "ERROR: eof"},
+	{`[ab](cd|ef)gh`, "xxacdgh bcdgx aefgh"},
+	{`[0-9]+ms`, "took 120ms, then 7ms"},
+	{`(.)日本`, "x日本語 日本"},
+}
+
+func TestInnerPrefilter(t *testing.T) {
+	for i, v := range innerTests {
+		re := MustCompile(v.re)
+		if re.prefilter == nil || len(re.prefilter.inner) == 0 {
+			t.Errorf("%d: `%s`: no inner literals", i, v.re)
+			continue
+		}
+
+		re0 := re.Copy()
+		re0.prefilter = nil
+		re2 := regexp.MustCompile(v.re)
+		g := re.FindAllStringSubmatchIndex(v.src, -1)
+		if e := re0.FindAllStringSubmatchIndex(v.src, -1); !reflect.DeepEqual(g, e) {
+			t.Errorf("%d: `%s` %q: got %v exp %v", i, v.re, v.src, g, e)
+		}
+		if e := re2.FindAllStringSubmatchIndex(v.src, -1); !reflect.DeepEqual(g, e) {
+			t.Errorf("%d: `%s` %q: got %v exp %v (stdlib)", i, v.re, v.src, g, e)
+		}
+		if g := re.FindAllSubmatchIndex([]byte(v.src), -1); !reflect.DeepEqual(g, re2.FindAllSubmatchIndex([]byte(v.src), -1)) {
+			t.Errorf("%d: `%s` %q: got %v (bytes)", i, v.re, v.src, g)
+		}
+		if g, e := re.Match([]byte(v.src)), re2.MatchString(v.src); g != e {
+			t.Errorf("%d: `%s` %q: got %v exp %v", i, v.re, v.src, g, e)
+		}
+	}
+}
+
+const benchmarkInnerRe = `[a-z]+@example\.com`
+
+var benchmarkInnerStr = strings.Repeat("jdoe@example.org, root@localhost; ", 1000)
+
+func benchmarkInner0(b *testing.B, re regexper) {
+	b.SetBytes(int64(len(benchmarkInnerStr)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if re.MatchString(benchmarkInnerStr) {
+			b.Fatal()
+		}
+	}
+}
+
+func BenchmarkInner(b *testing.B) {
+	benchmarkInner0(b, regexp.MustCompile(benchmarkInnerRe))
+}
+
+func BenchmarkInnerNew(b *testing.B) {
+	benchmarkInner0(b, MustCompile(benchmarkInnerRe))
+}
+
+var reverseTests = []struct {
+	re, src string
+	reverse bool
+}{
+	{`\.(jpg|png)$`, "/home/user/pictures/a.png", true},
+	{`\.(jpg|png)$`, "/home/user/pictures/a.png.txt", true},
+	{`\.(jpg|png)\z`, "a.jpg.jpg", true},
+	{`[a-z]+\.go$`, "src/main.go", true},
+	{`[a-z]+\.go$`, "src/main.go/x", true},
+	{`.*\.go$`, "src/main.go", true},
+	{`a*$`, "baaa", true},
+	{`a*$`, "", true},
+	{`(a|ab)(c|bcd)$`, "xabcd", true},
+	{`x*$|y$`, "xxy", true},
+	{`[0-9]+$`, "abc 123", true},
+	{`日本$`, "日本語日本", true},
+	{`(?s).$`, "ab\n", true},
+	{`.$`, "ab\n", true},
+	{`a$b`, "a", false},
+	{`abc`, "abc", false},
+	{`a$|b`, "ab", false},
+	{`^abc$`, "abc", false},
+	{`^abc$`, "xabc", false},
+	{`^a*$`, "", false},
+	{`\babc$`, "x abc", false},
+	{`(?m)abc$`, "abc", false},
+}
+
+func TestReverse(t *testing.T) {
+	for i, v := range reverseTests {
+		re := MustCompile(v.re)
+		if g, e := re.reverse != nil, v.reverse; g != e {
+			t.Errorf("%d: `%s`: got %v exp %v", i, v.re, g, e)
+			continue
+		}
+
+		if re.reverse == nil {
+			continue
+		}
+
+		re0 := re.Copy()
+		re0.reverse = nil
+		re2 := regexp.MustCompile(v.re)
+		g := re.FindAllStringSubmatchIndex(v.src, -1)
+		if e := re0.FindAllStringSubmatchIndex(v.src, -1); !reflect.DeepEqual(g, e) {
+			t.Errorf("%d: `%s` %q: got %v exp %v", i, v.re, v.src, g, e)
+		}
+		if e := re2.FindAllStringSubmatchIndex(v.src, -1); !reflect.DeepEqual(g, e) {
+			t.Errorf("%d: `%s` %q: got %v exp %v (stdlib)", i, v.re, v.src, g, e)
+		}
+		if g := re.FindAllSubmatchIndex([]byte(v.src), -1); !reflect.DeepEqual(g, re2.FindAllSubmatchIndex([]byte(v.src), -1)) {
+			t.Errorf("%d: `%s` %q: got %v (bytes)", i, v.re, v.src, g)
+		}
+		if g, e := re.MatchString(v.src), re2.MatchString(v.src); g != e {
+			t.Errorf("%d: `%s` %q: got %v exp %v", i, v.re, v.src, g, e)
+		}
+		if g, e := re.Match([]byte(v.src)), re2.MatchString(v.src); g != e {
+			t.Errorf("%d: `%s` %q: got %v exp %v (bytes)", i, v.re, v.src, g, e)
+		}
+	}
+}
+
+const benchmarkReverseRe = `\.(jpg|png)$`
+
+var benchmarkReverseStr = strings.Repeat("/very/long/path/to/some/directory", 1000) + "/photo.png"
+
+func benchmarkReverse0(b *testing.B, re regexper) {
+	b.SetBytes(int64(len(benchmarkReverseStr)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if !re.MatchString(benchmarkReverseStr) {
+			b.Fatal()
+		}
+	}
+}
+
+func BenchmarkReverse(b *testing.B) {
+	benchmarkReverse0(b, regexp.MustCompile(benchmarkReverseRe))
+}
+
+func BenchmarkReverseNew(b *testing.B) {
+	benchmarkReverse0(b, MustCompile(benchmarkReverseRe))
+}
+
+var anchoredTests = []struct {
+	re, src  string
+	anchored bool
+}{
+	{`^abc`, "abcabc", true},
+	{`^abc`, "xabc", true},
+	{`\Aabc`, "abcabc", true},
+	{`^`, "abc", true},
+	{`^a*`, "aaba", true},
+	{`^a|^b`, "ba", true},
+	{`(^a)|(^b)`, "ab", true},
+	{`^zbc(d|e)`, "abcdefghijklmnopqrstuvwxyz", true},
+	{`^abc$`, "abc", true},
+	{`^a|b`, "ab", false},
+	{`a^`, "a", false},
+	{`(^)*a`, "aa", false},
+	{`abc`, "abc", false},
+}
+
+func TestAnchored(t *testing.T) {
+	for i, v := range anchoredTests {
+		re := MustCompile(v.re)
+		if g, e := re.anchored, v.anchored; g != e {
+			t.Errorf("%d: `%s`: got %v exp %v", i, v.re, g, e)
+			continue
+		}
+
+		re0 := re.Copy()
+		re0.anchored = false
+		re2 := regexp.MustCompile(v.re)
+		g := re.FindAllStringSubmatchIndex(v.src, -1)
+		if e := re0.FindAllStringSubmatchIndex(v.src, -1); !reflect.DeepEqual(g, e) {
+			t.Errorf("%d: `%s` %q: got %v exp %v", i, v.re, v.src, g, e)
+		}
+		if e := re2.FindAllStringSubmatchIndex(v.src, -1); !reflect.DeepEqual(g, e) {
+			t.Errorf("%d: `%s` %q: got %v exp %v (stdlib)", i, v.re, v.src, g, e)
+		}
+		if g, e := re.MatchString(v.src), re2.MatchString(v.src); g != e {
+			t.Errorf("%d: `%s` %q: got %v exp %v", i, v.re, v.src, g, e)
+		}
+		if g, e := re.ReplaceAllString(v.src, "<$0>"), re2.ReplaceAllString(v.src, "<$0>"); g != e {
+			t.Errorf("%d: `%s` %q: got %q exp %q", i, v.re, v.src, g, e)
+		}
+		if g, e := string(re.ReplaceAll([]byte(v.src), []byte("<$0>"))), re2.ReplaceAllString(v.src, "<$0>"); g != e {
+			t.Errorf("%d: `%s` %q: got %q exp %q (bytes)", i, v.re, v.src, g, e)
+		}
+	}
+}
+
+func TestUTF8Sequences(t *testing.T) {
+	for _, v := range [][2]rune{
+		{0, unicode.MaxRune},
+		{'a', 'z'},
+		{0x70, 0x90},
+		{0x7ff, 0x800},
+		{0xd000, 0xe100},
+		{0x3b1, 0x3c9},
+		{0xfff0, 0x10010},
+		{0x10ffff, 0x10ffff},
+		{0xd800, 0xdfff},
+	} {
+		seqs := utf8Sequences(nil, v[0], v[1])
+		var b [utf8.UTFMax]byte
+		for c := rune(0); c <= unicode.MaxRune; c++ {
+			if !utf8.ValidRune(c) {
+				continue
+			}
+
+			n := utf8.EncodeRune(b[:], c)
+			m := 0
+		seq:
+			for _, seq := range seqs {
+				if len(seq) != n {
+					continue
+				}
+
+				for i, r := range seq {
+					if b[i] < r[0] || b[i] > r[1] {
+						continue seq
+					}
+				}
+				m++
+			}
+			if g, e := m, 0; c >= v[0] && c <= v[1] {
+				if e = 1; g != e {
+					t.Fatalf("%#x-%#x: %#x: got %v exp %v", v[0], v[1], c, g, e)
+				}
+			} else if g != e {
+				t.Fatalf("%#x-%#x: %#x: got %v exp %v", v[0], v[1], c, g, e)
+			}
+		}
+	}
+}
+
+func TestByteClasses(t *testing.T) {
+	for i, v := range []struct {
+		re     string
+		nclass int
+	}{
+		{`a`, 3},
+		{`abc`, 5},
+		{`[a-c]x`, 5},
+		{`[^a]`, 16},
+		{`\b`, 1},
+	} {
+		re := MustCompile(v.re)
+		if re.byteProg() == nil {
+			t.Errorf("%d: `%s`: no byte program", i, v.re)
+			continue
+		}
+
+		if g, e := re.byteProg().nclass, v.nclass; g != e {
+			t.Errorf("%d: `%s`: got %v exp %v", i, v.re, g, e)
+		}
+	}
+}
+
+func TestByteProg(t *testing.T) {
+	for i, v := range []struct {
+		re  string
+		src []string
+	}{
+		{`abc`, []string{"", "abc", "xabcx", "ab"}},
+		{`a.c`, []string{"abc", "a日c", "a\nc", "ac"}},
+		{`(?s)a.c`, []string{"abc", "a日c", "a\nc", "ac"}},
+		{`[α-ω]+`, []string{"abc", "αβγ", "ΑΒΓ"}},
+		{`x[^α-ω]y`, []string{"xαy", "xay", "x日y", "x😀y", "xy"}},
+		{`[^a]`, []string{"", "a", "aa", "ab", "日"}},
+		{`^日本$`, []string{"日本", "日本語", "x日本"}},
+		{`(a|b)*c`, []string{"ababc", "abab", "c"}},
+		{`[\d]+`, []string{"abc", "a1"}},
+		{`[^\s]`, []string{" \t\n", " x "}},
+		{`\bfoo\b`, []string{"foo", "x foo y", "xfoo"}},
+		{"😀|\U0010FFFF", []string{"😀", "\U0010FFFF", "x"}},
+	} {
+		re, err := Compile(v.re)
+		if err != nil {
+			continue
+		}
+
+		if re.byteProg() == nil {
+			t.Errorf("%d: `%s`: no byte program", i, v.re)
+			continue
+		}
+
+		re2 := regexp.MustCompile(v.re)
+		for _, s := range v.src {
+			e := re2.MatchString(s)
+			if g := re.byteProg().match(nil, s); g != e {
+				t.Errorf("%d: `%s` %q: got %v exp %v", i, v.re, s, g, e)
+			}
+			if g := re.byteProg().match([]byte(s), ""); g != e {
+				t.Errorf("%d: `%s` %q: got %v exp %v (bytes)", i, v.re, s, g, e)
+			}
+		}
+	}
+
+	// Invalid UTF-8 never matches.
+	re := MustCompile(`a.b`)
+	for _, s := range []string{"a\xffb", "a\xe6\x97b", "a\xed\xa0\x80b"} {
+		if re.byteProg().match(nil, s) {
+			t.Errorf("%q: unexpected match", s)
+		}
+	}
+	if !re.byteProg().match(nil, "\xffa\xe6\x97\xa5b") {
+		t.Error("missing match")
+	}
+
+	// Match uses the byte program for valid UTF-8 input only.
+	for i, v := range []struct {
+		re, src     string
+		bytes, want bool
+	}{
+		{`[a-c]{70}|x.y`, "x日y", true, true},
+		{`[a-c]{70}|x.y`, "xy", true, false},
+		{`[a-c]{70}|x.y`, "x\xffy", false, true},
+		{`[a-c]$|x.y`, "xa", true, true},
+		{`[a-c]$|x.y`, "ax", true, false},
+		{`[a-c]\b|x.y`, "xa", false, true},
+	} {
+		re := MustCompile(v.re)
+		if g, e := newStringVM(re, v.src).byteMatch(), v.bytes; g != e {
+			t.Errorf("%d: `%s` %q: got %v exp %v", i, v.re, v.src, g, e)
+		}
+		if g, e := re.MatchString(v.src), v.want; g != e {
+			t.Errorf("%d: `%s` %q: got %v exp %v", i, v.re, v.src, g, e)
+		}
+		if g, e := re.Match([]byte(v.src)), v.want; g != e {
+			t.Errorf("%d: `%s` %q: got %v exp %v (bytes)", i, v.re, v.src, g, e)
+		}
+	}
+}
+
+func TestEscX(t *testing.T) {
+	for i, v := range []string{
+		`\x`,
+		`\x4`,
+		`\x4g`,
+		`\x{`,
+		`\x{}`,
+		`\x{41`,
+		`\x{110000}`,
+		`\x{zz}`,
+		`a\x{41}b`,
+		`\x41\x42`,
+		`[\x41-\x{5a}]+`,
+		`\x{65e5}\x{672c}`,
+		`\x{10FFFF}`,
+	} {
+		re, err := Compile(v)
+		re2, err2 := regexp.Compile(v)
+		if g, e := fmt.Sprint(err), fmt.Sprint(err2); g != e {
+			t.Errorf("%d: `%s`: got %v exp %v", i, v, g, e)
+			continue
+		}
+
+		if err != nil {
+			continue
+		}
+
+		const s = "xAB日本\U0010FFFFaAbZ"
+		if g, e := re.FindAllStringIndex(s, -1), re2.FindAllStringIndex(s, -1); !reflect.DeepEqual(g, e) {
+			t.Errorf("%d: `%s`: got %v exp %v", i, v, g, e)
+		}
+	}
+}
+
+// rawReader hides the io.ByteReader of a bytes.Reader.
+type rawReader struct{ r io.RuneReader }
+
+func (r rawReader) ReadRune() (rune, int, error) { return r.r.ReadRune() }
+
+func TestRaw(t *testing.T) {
+	for i, v := range []struct {
+		re, src string
+		exp     [][]int
+	}{
+		{`\xff`, "a\xffb\xff", [][]int{{1, 2}, {3, 4}}},
+		{`.`, "\xe6\x97\xa5", [][]int{{0, 1}, {1, 2}, {2, 3}}},
+		{`日`, "x日", [][]int{{1, 4}}},
+		{`\x{65e5}`, "x日", [][]int{{1, 4}}},
+		{`[\x80-\xff]+`, "ab\xe6\x97\xa5c\xff", [][]int{{2, 5}, {6, 7}}},
+		{`\xef\xbf\xbd`, "\xff\xef\xbf\xbd", [][]int{{1, 4}}},
+		{`\xef\xbf\xbd`, "\xff", nil},
+		{`a\xe9`, "a\xe9 aé", [][]int{{0, 2}}},
+		{`aé`, "a\xe9 aé", [][]int{{0, 2}}},
+		{`[^\x00-\x7f]`, "a\xe9b", [][]int{{1, 2}}},
+		{`\xffGET`, "GET \xffGET", [][]int{{4, 8}}},
+		{`(\x00\x01|\x00\x02)`, "\x00\x00\x02\x00\x01", [][]int{{1, 3}, {3, 5}}},
+		{`[a-z]+\xff\xfe`, "abc\xff\xfd xyz\xff\xfe", [][]int{{6, 11}}},
+		{`\xff$`, "\xff\xff", [][]int{{1, 2}}},
+		{`^\xe6`, "\xe6\x97\xa5", [][]int{{0, 1}}},
+		{`\b\xe6`, "\xe6\x97\xa5", nil},
+	} {
+		re := MustCompileRaw(v.re)
+		if g, e := re.FindAllStringIndex(v.src, -1), v.exp; !reflect.DeepEqual(g, e) {
+			t.Errorf("%d: `%s` %q: got %v exp %v", i, v.re, v.src, g, e)
+		}
+		if g, e := re.FindAllIndex([]byte(v.src), -1), v.exp; !reflect.DeepEqual(g, e) {
+			t.Errorf("%d: `%s` %q: got %v exp %v (bytes)", i, v.re, v.src, g, e)
+		}
+		if g, e := re.MatchString(v.src), v.exp != nil; g != e {
+			t.Errorf("%d: `%s` %q: got %v exp %v", i, v.re, v.src, g, e)
+		}
+
+		var e []int
+		if v.exp != nil {
+			e = v.exp[0]
+		}
+		if g := re.FindReaderIndex(bytes.NewReader([]byte(v.src))); !reflect.DeepEqual(g, e) {
+			t.Errorf("%d: `%s` %q: got %v exp %v (reader)", i, v.re, v.src, g, e)
+		}
+	}
+
+	// Runes read from a plain io.RuneReader are matched as UTF-8.
+	re := MustCompileRaw(`\xa5`)
+	if g, e := re.FindReaderIndex(rawReader{strings.NewReader("x日")}), []int{3, 4}; !reflect.DeepEqual(g, e) {
+		t.Errorf("got %v exp %v", g, e)
+	}
+
+	if g, e := MustCompileRaw(`\xffGET`).prefix, "\xffGET"; g != e {
+		t.Errorf("got %q exp %q", g, e)
+	}
+
+	if _, err := CompileRaw(`[日]`); err == nil {
+		t.Error("unexpected success")
+	}
+}
+
+var bitTests = []struct {
+	re  string
+	src []string
+}{
+	{`[a-c]+[0-9]{2}[x-z]`, []string{"", "ab12x", "ab1x", "zzc00zz", "a1", "日b99y"}},
+	{`(a|b)*[c-d]`, []string{"ababc", "abab", "d", ""}},
+	{`[ab](c|bcd)(d*)`, []string{"abcd", "abc", "xabcd", "bd"}},
+	{`.[^x]`, []string{"", "a", "ab", "ax", "日\n", "\n日"}},
+	{`(?s).[^x]`, []string{"\n日", "\nx"}},
+	{`[α-ω]+[^α-ω]`, []string{"αβγ", "αβγd", "ααα日"}},
+	{`[\dx][\s,][\w_]`, []string{"1 c", "1\tZ", "1 日", "12c"}},
+	{`^[a-c]+[0-9]`, []string{"abc1", "xabc1", "abc"}},
+	{`^`, []string{"", "a"}},
+	{`(^)*[a-c]`, []string{"", "a", "xb"}},
+	{`^[a-c]|[x-z]`, []string{"", "a", "da", "dx"}},
+	{`[a-c]^`, []string{"", "a"}},
+	{`[a-c]*`, []string{"", "x"}},
+	{`[^a-c]?[d-f]`, []string{"xd", "ad", "a"}},
+	{`[a-b][b-c][c-d][d-e]|[c-d]`, []string{"abcd", "xabce", "abx", "abcx bcde"}},
+	{`[a-c]+[x-z]|[b-c][0-9]`, []string{"aaab1", "aaab1aax", "c", "c1 abz"}},
+}
+
+func TestBitProg(t *testing.T) {
+	for i, v := range bitTests {
+		re := MustCompile(v.re)
+		if re.bits == nil {
+			t.Errorf("%d: `%s`: no bit program", i, v.re)
+			continue
+		}
+
+		re0 := re.Copy()
+		re0.bits = nil
+		re2 := regexp.MustCompile(v.re)
+		for _, s := range v.src {
+			e := re2.MatchString(s)
+			if g := re.MatchString(s); g != e {
+				t.Errorf("%d: `%s` %q: got %v exp %v", i, v.re, s, g, e)
+			}
+			if g := re.Match([]byte(s)); g != e {
+				t.Errorf("%d: `%s` %q: got %v exp %v (bytes)", i, v.re, s, g, e)
+			}
+			if g := re0.MatchString(s); g != e {
+				t.Errorf("%d: `%s` %q: got %v exp %v (VM)", i, v.re, s, g, e)
+			}
+			if g, e := re.FindStringIndex(s), re0.FindStringIndex(s); !reflect.DeepEqual(g, e) {
+				t.Errorf("%d: `%s` %q: got %v exp %v", i, v.re, s, g, e)
+			}
+			if g, e := re.FindIndex([]byte(s)), re0.FindIndex([]byte(s)); !reflect.DeepEqual(g, e) {
+				t.Errorf("%d: `%s` %q: got %v exp %v (bytes)", i, v.re, s, g, e)
+			}
+			if g, e := re.FindAllStringSubmatchIndex(s, -1), re0.FindAllStringSubmatchIndex(s, -1); !reflect.DeepEqual(g, e) {
+				t.Errorf("%d: `%s` %q: got %v exp %v (all)", i, v.re, s, g, e)
+			}
+		}
+	}
+
+	for i, v := range []string{
+		`abc`,
+		`\bx[a-c]`,
+		`[a-c]$`,
+		`[\Dx]y`,
+		`a|\bb`,
+		strings.Repeat("[ab]", 65),
+	} {
+		if MustCompile(v).bits != nil {
+			t.Errorf("%d: `%s`: unexpected bit program", i, v)
+		}
+	}
+}
+
+const benchmarkBitRe = `[a-c]+[0-9]{2}[x-z]`
+
+var benchmarkBitStr = strings.Repeat("abc1x abcd12 bcx99 ", 1000)
+
+func benchmarkBit0(b *testing.B, re regexper) {
+	b.SetBytes(int64(len(benchmarkBitStr)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if re.MatchString(benchmarkBitStr) {
+			b.Fatal()
+		}
+	}
+}
+
+func BenchmarkBit(b *testing.B) {
+	benchmarkBit0(b, regexp.MustCompile(benchmarkBitRe))
+}
+
+func BenchmarkBitNew(b *testing.B) {
+	benchmarkBit0(b, MustCompile(benchmarkBitRe))
+}
+
+func benchmarkBitFind(b *testing.B, re interface{ FindStringIndex(string) []int }) {
+	s := benchmarkBitStr + "ab12x"
+	b.SetBytes(int64(len(s)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if re.FindStringIndex(s) == nil {
+			b.Fatal()
+		}
+	}
+}
+
+func BenchmarkBitFind(b *testing.B) {
+	benchmarkBitFind(b, regexp.MustCompile(benchmarkBitRe))
+}
+
+func BenchmarkBitFindNew(b *testing.B) {
+	benchmarkBitFind(b, MustCompile(benchmarkBitRe))
+}
+
+func TestSpecialize(t *testing.T) {
+	var a []string
+	for _, v := range findTests {
+		a = append(a, v.pat)
+	}
+	for _, v := range innerTests {
+		a = append(a, v.re)
+	}
+	for _, v := range prefilterTests {
+		a = append(a, v.re)
+	}
+	for i, v := range a {
+		re := MustCompile(v)
+		re.code = nil
+		re2 := re.Specialize()
+		if re.code != nil || re2.code == nil {
+			t.Fatalf("%d: `%s`: Specialize modified re", i, v)
+		}
+
+		for _, s := range []string{"", "abc", "xabcx", "a\nb", "日本語 abc123 x@example.com", benchmarkAccessLog[:300]} {
+			if g, e := re2.FindAllStringSubmatchIndex(s, -1), re.FindAllStringSubmatchIndex(s, -1); !reflect.DeepEqual(g, e) {
+				t.Errorf("%d: `%s` %q: got %v exp %v", i, v, s, g, e)
+			}
+			if g, e := re2.MatchString(s), re.MatchString(s); g != e {
+				t.Errorf("%d: `%s` %q: got %v exp %v", i, v, s, g, e)
+			}
+		}
+	}
+}
+
+const benchmarkSpecializeRe = `[0-9]+[.][0-9]+[.][0-9]+[.][0-9]+ [a-z-]+ [a-z-]+ [[][^\]]*[]]`
+
+func benchmarkSpecialize0(b *testing.B, re *Regexp) {
+	s := benchmarkAccessLog[:4096]
+	b.SetBytes(int64(len(s)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if re.FindAllStringIndex(s, -1) == nil {
+			b.Fatal()
+		}
+	}
+}
+
+func BenchmarkSpecialize(b *testing.B) {
+	re := MustCompile(benchmarkSpecializeRe)
+	re.code = nil
+	benchmarkSpecialize0(b, re)
+}
+
+func BenchmarkSpecializeNew(b *testing.B) {
+	re := MustCompile(benchmarkSpecializeRe).Specialize()
+	benchmarkSpecialize0(b, re)
+}
+
+func TestMarshalBinary(t *testing.T) {
+	var a []string
+	for _, v := range findTests {
+		a = append(a, v.pat)
+	}
+	for _, v := range prefilterTests {
+		a = append(a, v.re)
+	}
+	inputs := []string{"", "abc", "xabcx", "a\nb", "foo bar", "日本語 abc123 x@example.com", benchmarkAccessLog[:300]}
+	for i, v := range a {
+		for _, re := range []*Regexp{MustCompile(v), MustCompilePOSIX(v)} {
+			b, err := re.MarshalBinary()
+			if err != nil {
+				t.Fatal(i, err)
+			}
+
+			var re2 Regexp
+			if err := re2.UnmarshalBinary(b); err != nil {
+				t.Fatalf("%d: `%s`: %v", i, v, err)
+			}
+
+			if g, e := re2.String(), re.String(); g != e {
+				t.Errorf("%d: got %q exp %q", i, g, e)
+			}
+
+			if g, e := re2.SubexpNames(), re.SubexpNames(); !reflect.DeepEqual(g, e) {
+				t.Errorf("%d: `%s`: got %q exp %q", i, v, g, e)
+			}
+
+			gp, gc := re2.LiteralPrefix()
+			ep, ec := re.LiteralPrefix()
+			if gp != ep || gc != ec {
+				t.Errorf("%d: `%s`: got %q %v exp %q %v", i, v, gp, gc, ep, ec)
+			}
+
+			if g, e := re2.anchored, re.anchored; g != e {
+				t.Errorf("%d: `%s`: got %v exp %v", i, v, g, e)
+			}
+
+			if !reflect.DeepEqual(re2.prefilter, re.prefilter) || !reflect.DeepEqual(re2.reverse, re.reverse) || !reflect.DeepEqual(re2.bits, re.bits) {
+				t.Errorf("%d: `%s`: analysis not restored", i, v)
+			}
+
+			for _, s := range inputs {
+				if g, e := re2.FindAllStringSubmatchIndex(s, -1), re.FindAllStringSubmatchIndex(s, -1); !reflect.DeepEqual(g, e) {
+					t.Errorf("%d: `%s` %q: got %v exp %v", i, v, s, g, e)
+				}
+				if g, e := re2.MatchString(s), re.MatchString(s); g != e {
+					t.Errorf("%d: `%s` %q: got %v exp %v", i, v, s, g, e)
+				}
+			}
+
+			// Damaged data must be rejected or produce a valid program.
+			for j := range b {
+				if err := re2.UnmarshalBinary(b[:j]); err == nil {
+					t.Errorf("%d: `%s`: truncated at %d: expected error", i, v, j)
+				}
+
+				c := append([]byte(nil), b...)
+				c[j] ^= 0x55
+				var re3 Regexp
+				if err := re3.UnmarshalBinary(c); err == nil {
+					if err := re3.validate(); err != nil {
+						t.Errorf("%d: `%s`: damaged at %d: %v", i, v, j, err)
+					}
+				}
+			}
+		}
+	}
+
+	re := MustCompileRaw(`\xff+`)
+	b, err := re.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var re2 Regexp
+	if err := re2.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := re2.FindStringIndex("a\xff\xffb"), []int{1, 3}; !reflect.DeepEqual(g, e) {
+		t.Errorf("got %v exp %v", g, e)
+	}
+
+	for _, v := range []byte{1, binaryVersion + 1} {
+		c := append([]byte(nil), b...)
+		c[len(binaryMagic)] = v
+		if err := re2.UnmarshalBinary(c); err == nil {
+			t.Errorf("version %v: expected error", v)
+		}
+	}
+
+	d := &binaryDecoder{b: b[len(binaryMagic)+1:]}
+	c := appendUvarint(append([]byte(nil), b[:len(binaryMagic)+1]...), d.uvarint()|(binaryFlags+1))
+	if err := re2.UnmarshalBinary(append(c, d.b...)); err == nil {
+		t.Error("unknown flag: expected error")
+	}
+}
+
+// BenchmarkUnmarshalBinary compares loading the programs of findTests to
+// compiling them.
+func BenchmarkUnmarshalBinary(b *testing.B) {
+	var a []string
+	var data [][]byte
+	for _, v := range findTests {
+		d, err := MustCompile(v.pat).MarshalBinary()
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		a = append(a, v.pat)
+		data = append(data, d)
+	}
+	b.Run("Compile", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, v := range a {
+				if _, err := Compile(v); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+	b.Run("UnmarshalBinary", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, v := range data {
+				var re Regexp
+				if err := re.UnmarshalBinary(v); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+}
+
+func TestMarshalText(t *testing.T) {
+	longest := MustCompile(`a+?`)
+	longest.Longest()
+	for i, re := range []*Regexp{
+		MustCompile(`a+?`),
+		MustCompilePOSIX(`a+?`),
+		MustCompileRaw(`\xff+`),
+		longest,
+	} {
+		b, err := re.MarshalText()
+		if err != nil {
+			t.Fatal(i, err)
+		}
+
+		var re2 Regexp
+		if err := re2.UnmarshalText(b); err != nil {
+			t.Fatalf("%d: %q: %v", i, b, err)
+		}
+
+		if g, e := re2.String(), re.String(); g != e {
+			t.Errorf("%d: got %q exp %q", i, g, e)
+		}
+
+		if g, e := [3]bool{re2.posix, re2.raw, re2.longest}, [3]bool{re.posix, re.raw, re.longest}; g != e {
+			t.Errorf("%d: %q: got %v exp %v", i, b, g, e)
+		}
+
+		b2, err := re2.MarshalText()
+		if err != nil {
+			t.Fatal(i, err)
+		}
+
+		if !bytes.Equal(b2, b) {
+			t.Errorf("%d: got %q exp %q", i, b2, b)
+		}
+
+		// The standard library rejects the mode prefixes.
+		_, err = regexp.Compile(string(b))
+		if g, e := err == nil, !re.posix && !re.raw && !re.longest; g != e {
+			t.Errorf("%d: %q: got %v exp %v", i, b, g, e)
+		}
+	}
+
+	// The text of a regexp of the standard library is its source text.
+	b := []byte(regexp.MustCompile(`a+?`).String())
+	var re2 Regexp
+	if err := re2.UnmarshalText(b); err != nil || re2.posix || re2.raw || re2.longest {
+		t.Errorf("%q: %v %v %v %v", b, err, re2.posix, re2.raw, re2.longest)
+	}
+
+	// The prefixes are accepted at the start of text only.
+	for _, s := range []string{"a(?raw)", "(?longest)(?POSIX)a"} {
+		if err := re2.UnmarshalText([]byte(s)); err == nil {
+			t.Errorf("%q: unexpected success", s)
+		}
+	}
+
+	var cfg struct {
+		Name  *Regexp
+		Names []*Regexp
+	}
+	if err := json.Unmarshal([]byte(`{"Name": "(?POSIX)a|ab", "Names": ["x+", "(?longest)y+?"]}`), &cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := cfg.Name.FindString("ab"), "a"; g != e {
+		t.Errorf("got %q exp %q", g, e)
+	}
+
+	b, err := json.Marshal(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := string(b), `{"Name":"(?POSIX)a|ab","Names":["x+","(?longest)y+?"]}`; g != e {
+		t.Errorf("got %s exp %s", g, e)
+	}
+
+	_, err = Compile(`a(b`)
+	e, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("%T", err)
+	}
+
+	err = json.Unmarshal([]byte(`{"Name": "(?POSIX)a(b"}`), &cfg)
+	e2, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("%T", err)
+	}
+
+	if g, e := e2.Offset, e.Offset+len("(?POSIX)"); g != e {
+		t.Errorf("got %v exp %v", g, e)
+	}
+
+	if g, e := e2.Error(), e.Error(); g != e {
+		t.Errorf("got %q exp %q", g, e)
+	}
+
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	var re Regexp
+	fs.Var(&re, "re", "")
+	if err := fs.Parse([]string{"-re", "(?raw)[a-c]+"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := re.FindString("xabc"), "abc"; g != e || !re.raw {
+		t.Errorf("got %q exp %q", g, e)
+	}
+}
+
+func TestMatchResult(t *testing.T) {
+	for i, test := range findTests {
+		re := MustCompile(test.pat)
+		e := re.FindStringSubmatchIndex(test.text)
+		for j, m := range []*MatchResult{
+			re.FindStringMatch(test.text),
+			re.FindMatch([]byte(test.text)),
+			re.FindReaderMatch(strings.NewReader(test.text)),
+		} {
+			if (m == nil) != (e == nil) {
+				t.Fatalf("%d.%d: %s: got %v exp %v", i, j, &test, m, e)
+			}
+
+			if m == nil {
+				continue
+			}
+
+			if g, e := [2]int{m.Start(), m.End()}, [2]int{e[0], e[1]}; g != e {
+				t.Errorf("%d.%d: %s: got %v exp %v", i, j, &test, g, e)
+			}
+
+			if g, e := m.Text(), test.text[e[0]:e[1]]; g != e {
+				t.Errorf("%d.%d: %s: got %q exp %q", i, j, &test, g, e)
+			}
+
+			if g, e := m.NumGroups(), len(e)/2; g != e {
+				t.Errorf("%d.%d: %s: got %v exp %v", i, j, &test, g, e)
+			}
+
+			for k := 0; k < len(e)/2; k++ {
+				s, x := m.GroupSpan(k)
+				if g, e := [2]int{s, x}, [2]int{e[2*k], e[2*k+1]}; g != e {
+					t.Errorf("%d.%d: %s: %d: got %v exp %v", i, j, &test, k, g, e)
+				}
+
+				var eg string
+				if e[2*k] >= 0 {
+					eg = test.text[e[2*k]:e[2*k+1]]
+				}
+				if g, e := m.Group(k), eg; g != e {
+					t.Errorf("%d.%d: %s: %d: got %q exp %q", i, j, &test, k, g, e)
+				}
+
+				if g, e := m.Participated(k), e[2*k] >= 0; g != e {
+					t.Errorf("%d.%d: %s: %d: got %v exp %v", i, j, &test, k, g, e)
+				}
+			}
+			if m.Participated(-1) || m.Participated(len(e)/2) || m.Group(len(e)/2) != "" {
+				t.Errorf("%d.%d: %s: group out of range", i, j, &test)
+			}
+		}
+	}
+
+	re := MustCompile(`(?P<key>[a-z]+)=(?:(?P<val>[0-9]+)|(?P<val>[a-z]+))`)
+	m := re.FindStringMatch("x: foo=bar")
+	if m == nil {
+		t.Fatal("no match")
+	}
+
+	if g, e := m.Named("val"), "bar"; g != e {
+		t.Errorf("got %q exp %q", g, e)
+	}
+
+	if s, x := m.Span("val"); s != 7 || x != 10 {
+		t.Errorf("got %v %v", s, x)
+	}
+
+	if s, x := m.Span("missing"); s != -1 || x != -1 || m.Named("missing") != "" {
+		t.Errorf("got %v %v", s, x)
+	}
+
+	if m.Participated(2) || !m.Participated(3) {
+		t.Error("Participated")
+	}
+
+	if g, e := m.Expand("${val}:$key"), "bar:foo"; g != e {
+		t.Errorf("got %q exp %q", g, e)
+	}
+
+	if m := MustCompileRaw(`\xff(.)`).FindReaderMatch(strings.NewReader("a\xff\xfeb")); m == nil || m.Group(1) != "\xfe" {
+		t.Errorf("got %v", m)
+	}
+}
+
+// streamSource reads buf repeated n times.
+type streamSource struct {
+	buf []byte
+	i   int
+	n   int
+}
+
+func (s *streamSource) Read(b []byte) (n int, err error) {
+	for n < len(b) && s.n != 0 {
+		c := copy(b[n:], s.buf[s.i:])
+		n += c
+		if s.i += c; s.i == len(s.buf) {
+			s.i = 0
+			s.n--
+		}
+	}
+	if n == 0 {
+		return 0, io.EOF
+	}
+
+	return n, nil
+}
+
+type failWriter int
+
+func (w *failWriter) Write(b []byte) (int, error) {
+	if *w -= failWriter(len(b)); *w < 0 {
+		return 0, fmt.Errorf("write failed")
+	}
+
+	return len(b), nil
+}
+
+func TestReplaceAllReader(t *testing.T) {
+	for _, tc := range replaceTests {
+		re := MustCompile(tc.pattern)
+		var b bytes.Buffer
+		if err := re.ReplaceAllReader(&b, strings.NewReader(tc.input), []byte(tc.replacement)); err != nil {
+			t.Fatal(err)
+		}
+
+		if g, e := b.String(), tc.output; g != e {
+			t.Errorf("%q.ReplaceAllReader(%q,%q) = %q; want %q", tc.pattern, tc.input, tc.replacement, g, e)
+		}
+	}
+	for _, tc := range append(replaceTests, replaceLiteralTests...) {
+		if strings.Contains(tc.replacement, "$") && !strings.Contains(tc.output, "$") {
+			continue
+		}
+
+		re := MustCompile(tc.pattern)
+		var b bytes.Buffer
+		if err := re.ReplaceAllLiteralReader(&b, strings.NewReader(tc.input), []byte(tc.replacement)); err != nil {
+			t.Fatal(err)
+		}
+
+		if g, e := b.String(), re.ReplaceAllLiteralString(tc.input, tc.replacement); g != e {
+			t.Errorf("%q.ReplaceAllLiteralReader(%q,%q) = %q; want %q", tc.pattern, tc.input, tc.replacement, g, e)
+		}
+	}
+	for _, tc := range replaceFuncTests {
+		re := MustCompile(tc.pattern)
+		var b bytes.Buffer
+		if err := re.ReplaceAllFuncReader(&b, strings.NewReader(tc.input), func(s []byte) []byte { return []byte(tc.replacement(string(s))) }); err != nil {
+			t.Fatal(err)
+		}
+
+		if g, e := b.String(), tc.output; g != e {
+			t.Errorf("%q.ReplaceAllFuncReader(%q,fn) = %q; want %q", tc.pattern, tc.input, g, e)
+		}
+	}
+
+	// Long input, compared with the in memory result.
+	re := MustCompile(`(?P<user>[a-z]+)@(?P<host>[a-z]+)[.]com`)
+	chunk := []byte("foo x@example.com \xff bar abc@def.com x@y.org 日本語\n")
+	src := &streamSource{buf: chunk, n: 1e4}
+	var b bytes.Buffer
+	if err := re.ReplaceAllReader(&b, src, []byte("<${user} at $host>")); err != nil {
+		t.Fatal(err)
+	}
+
+	e := re.ReplaceAll(bytes.Repeat(chunk, 1e4), []byte("<${user} at $host>"))
+	if !bytes.Equal(b.Bytes(), e) {
+		t.Fatalf("got %d bytes, exp %d", b.Len(), len(e))
+	}
+
+	// Memory use does not depend on the input size.
+	st := newStream(ioutil.Discard, &streamSource{buf: []byte("abc xx "), n: 1 << 16})
+	if err := st.replace(MustCompile(`x+y`), func(b []byte, a []int, base int) []byte { return b }); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := cap(st.buf); n > 4*streamTrim {
+		t.Errorf("buffered %d bytes", n)
+	}
+
+	// Raw regexps stream bytes.
+	b.Reset()
+	if err := MustCompileRaw(`\xff+`).ReplaceAllLiteralReader(&b, strings.NewReader("a\xff\xffb\xfe"), []byte("-")); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := b.String(), "a-b\xfe"; g != e {
+		t.Errorf("got %q exp %q", g, e)
+	}
+
+	w := failWriter(10)
+	if err := re.ReplaceAllReader(&w, &streamSource{buf: chunk, n: 1e4}, nil); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestSplitVariants(t *testing.T) {
+	for i, test := range splitTests {
+		re := MustCompile(test.r)
+		split := re.Split(test.s, test.n)
+		var b [][]byte
+		if split != nil {
+			b = [][]byte{}
+		}
+		for _, v := range split {
+			b = append(b, []byte(v))
+		}
+		if g := re.SplitBytes([]byte(test.s), test.n); !reflect.DeepEqual(g, b) {
+			t.Errorf("#%d: %q: SplitBytes got %q; want %q", i, test.r, g, b)
+		}
+
+		after := re.SplitAfter(test.s, test.n)
+		if len(after) != len(split) {
+			t.Errorf("#%d: %q: SplitAfter got %q; split %q", i, test.r, after, split)
+		}
+		if g, e := strings.Join(after, ""), test.s; test.n < 0 && g != e {
+			t.Errorf("#%d: %q: SplitAfter got %q; want %q", i, test.r, g, e)
+		}
+
+		if QuoteMeta(test.r) == test.r && test.r != "" {
+			if e := strings.SplitAfterN(test.s, test.r, test.n); !reflect.DeepEqual(after, e) && !(len(after) == 0 && len(e) == 0) {
+				t.Errorf("#%d: SplitAfter(%q, %q, %d): regexp vs strings mismatch\nregexp=%q\nstrings=%q", i, test.s, test.r, test.n, after, e)
+			}
+		}
+
+		pieces, delims := re.SplitWithDelims(test.s, test.n)
+		if !reflect.DeepEqual(pieces, split) {
+			t.Errorf("#%d: %q: SplitWithDelims got %q; want %q", i, test.r, pieces, split)
+		}
+		if n := len(pieces) - len(delims); n != 0 && n != 1 {
+			t.Errorf("#%d: %q: SplitWithDelims got %q %v", i, test.r, pieces, delims)
+			continue
+		}
+
+		var s string
+		for j, v := range pieces {
+			s += v
+			if j < len(delims) {
+				if len(delims[j]) != 2*(re.NumSubexp()+1) {
+					t.Errorf("#%d: %q: SplitWithDelims got %v", i, test.r, delims[j])
+				}
+				s += test.s[delims[j][0]:delims[j][1]]
+			}
+			if j < len(after) && !strings.HasPrefix(after[j], v) {
+				t.Errorf("#%d: %q: SplitAfter got %q; want prefix %q", i, test.r, after[j], v)
+			}
+		}
+		if g, e := s, test.s; (test.n < 0 || len(pieces) < test.n) && g != e {
+			t.Errorf("#%d: %q: SplitWithDelims got %q; want %q", i, test.r, g, e)
+		}
+	}
+
+	pieces, delims := MustCompile(`<(/?)([a-z]+)>`).SplitWithDelims("x<b>y</b>", -1)
+	if g, e := pieces, []string{"x", "y", ""}; !reflect.DeepEqual(g, e) {
+		t.Errorf("got %q exp %q", g, e)
+	}
+
+	if g, e := delims, [][]int{{1, 4, 2, 2, 2, 3}, {5, 9, 6, 7, 7, 8}}; !reflect.DeepEqual(g, e) {
+		t.Errorf("got %v exp %v", g, e)
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	for i, tc := range replaceTests {
+		re := MustCompile(tc.pattern)
+		tmpl, err := re.CompileTemplate(tc.replacement)
+		if err != nil {
+			// Refers to a group re does not have.
+			continue
+		}
+
+		if g, e := tmpl.String(), tc.replacement; g != e {
+			t.Errorf("#%d: got %q exp %q", i, g, e)
+		}
+
+		if g, e := re.ReplaceAllStringTemplate(tc.input, tmpl), tc.output; g != e {
+			t.Errorf("#%d: %q.ReplaceAllStringTemplate(%q,%q) = %q; want %q", i, tc.pattern, tc.input, tc.replacement, g, e)
+		}
+
+		if g, e := string(re.ReplaceAllTemplate([]byte(tc.input), tmpl)), tc.output; g != e {
+			t.Errorf("#%d: %q.ReplaceAllTemplate(%q,%q) = %q; want %q", i, tc.pattern, tc.input, tc.replacement, g, e)
+		}
+
+		var b bytes.Buffer
+		if err := re.ReplaceAllTemplateReader(&b, strings.NewReader(tc.input), tmpl); err != nil {
+			t.Fatal(err)
+		}
+
+		if g, e := b.String(), tc.output; g != e {
+			t.Errorf("#%d: %q.ReplaceAllTemplateReader(%q,%q) = %q; want %q", i, tc.pattern, tc.input, tc.replacement, g, e)
+		}
+
+		for _, m := range re.FindAllStringSubmatchIndex(tc.input, -1) {
+			if g, e := string(tmpl.ExpandString(nil, tc.input, m)), string(re.ExpandString(nil, tc.replacement, tc.input, m)); g != e {
+				t.Errorf("#%d: got %q exp %q", i, g, e)
+			}
+			if g, e := string(tmpl.Expand(nil, []byte(tc.input), m)), string(re.Expand(nil, []byte(tc.replacement), []byte(tc.input), m)); g != e {
+				t.Errorf("#%d: got %q exp %q", i, g, e)
+			}
+		}
+	}
+
+	re := MustCompile(`(?P<first>[a-z]+) (?P<last>[a-z]+)`)
+	for _, v := range []string{"$2", "${last}", "$$3 $first", "x$", "${1x"} {
+		if _, err := re.CompileTemplate(v); err != nil {
+			t.Errorf("%q: %v", v, err)
+		}
+	}
+	for _, v := range []string{"$3", "${missing}", "$1x", "${10}"} {
+		if _, err := re.CompileTemplate(v); err == nil {
+			t.Errorf("%q: expected error", v)
+		}
+	}
+
+	tmpl := re.MustCompileTemplate("${last}, $1 $$")
+	src := "xyz abc"
+	m := re.FindStringSubmatchIndex(src)
+	dst := make([]byte, 0, 100)
+	if n := testing.AllocsPerRun(100, func() { dst = tmpl.ExpandString(dst[:0], src, m) }); n != 0 {
+		t.Errorf("got %v allocations", n)
+	}
+
+	if g, e := string(dst), "abc, xyz $"; g != e {
+		t.Errorf("got %q exp %q", g, e)
+	}
+}
+
+func TestTemplateCaseEscapes(t *testing.T) {
+	re := MustCompile(`(?P<first>[a-zA-Zäöü]+)_(?P<last>[a-zA-Zäöü]+)(x)?`)
+	for i, tc := range []struct {
+		tmpl, src, exp string
+	}{
+		{`\U$1\E-$2`, "foo_bar", "FOO-bar"},
+		{`\L${1}\u$2`, "FOO_bar", "fooBar"},
+		{`\u\L$first $last`, "hELLO_WORLD", "Hello world"},
+		{`\l$1\U$2`, "ABC_def", "aBCDEF"},
+		{`\U$1\L$2\E$2`, "a_BC", "AbcBC"},
+		{`\u$3$1`, "abc_def", "Abc"},
+		{`\U$1 ä\E`, "äöü_x", "ÄÖÜ Ä"},
+		{`\ulit $1`, "abc_def", "Lit abc"},
+		{`\\U$1\x\`, "abc_def", `\Uabc\x\`},
+		{`\U$$1`, "abc_def", "$1"},
+		{`\u`, "abc_def", ""},
+		{"\\Ux\xff$1", "abc_def", "X\xffABC"},
+	} {
+		tmpl, err := re.CompileTemplateMode(tc.tmpl, CaseEscapes)
+		if err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+
+		if g, e := re.ReplaceAllStringTemplate(tc.src, tmpl), tc.exp; g != e {
+			t.Errorf("#%d: %q on %q: got %q exp %q", i, tc.tmpl, tc.src, g, e)
+		}
+
+		if g, e := string(re.ReplaceAllTemplate([]byte(tc.src), tmpl)), tc.exp; g != e {
+			t.Errorf("#%d: %q on %q: got %q exp %q", i, tc.tmpl, tc.src, g, e)
+		}
+	}
+
+	// The escapes are literal text without the mode.
+	if g, e := re.ReplaceAllStringTemplate("abc_def", re.MustCompileTemplate(`\U$1`)), `\Uabc`; g != e {
+		t.Errorf("got %q exp %q", g, e)
+	}
+
+	if _, err := re.CompileTemplateMode(`\U$4`, CaseEscapes); err == nil {
+		t.Error("expected error")
+	}
+
+	tmpl := re.MustCompileTemplateMode(`\U${last}\E, \u$1`, CaseEscapes)
+	src := []byte("xyz_abc")
+	m := re.FindSubmatchIndex(src)
+	dst := make([]byte, 0, 100)
+	if n := testing.AllocsPerRun(100, func() { dst = tmpl.Expand(dst[:0], src, m) }); n != 0 {
+		t.Errorf("got %v allocations", n)
+	}
+
+	if g, e := string(dst), "ABC, Xyz"; g != e {
+		t.Errorf("got %q exp %q", g, e)
+	}
+}
+
+func TestReplaceAllSubmatchFunc(t *testing.T) {
+	for _, tc := range replaceFuncTests {
+		re := MustCompile(tc.pattern)
+		g, err := re.ReplaceAllStringSubmatchFunc(tc.input, -1, func(groups []string, match []int) (string, error) {
+			if groups[0] != tc.input[match[0]:match[1]] {
+				t.Errorf("%q: got %q exp %q", tc.pattern, groups[0], tc.input[match[0]:match[1]])
+			}
+			return tc.replacement(groups[0]), nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if e := tc.output; g != e {
+			t.Errorf("%q.ReplaceAllStringSubmatchFunc(%q,fn) = %q; want %q", tc.pattern, tc.input, g, e)
+		}
+
+		b, err := re.ReplaceAllSubmatchFunc([]byte(tc.input), -1, func(match []int) ([]byte, error) {
+			return []byte(tc.replacement(tc.input[match[0]:match[1]])), nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if g, e := string(b), tc.output; g != e {
+			t.Errorf("%q.ReplaceAllSubmatchFunc(%q,fn) = %q; want %q", tc.pattern, tc.input, g, e)
+		}
+	}
+
+	re := MustCompile(`(?P<key>[a-z]+)=(?P<val>[0-9]+)?`)
+	src := "a=1 b= c=3 d=4"
+	swap := func(groups []string, match []int) (string, error) {
+		return fmt.Sprintf("%s:%s@%d", groups[2], groups[1], match[0]), nil
+	}
+	for i, tc := range []struct {
+		n   int
+		exp string
+	}{
+		{-1, "1:a@0 :b@4 3:c@7 4:d@11"},
+		{0, src},
+		{2, "1:a@0 :b@4 c=3 d=4"},
+		{10, "1:a@0 :b@4 3:c@7 4:d@11"},
+	} {
+		g, err := re.ReplaceAllStringSubmatchFunc(src, tc.n, swap)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if g != tc.exp {
+			t.Errorf("#%d: got %q exp %q", i, g, tc.exp)
+		}
+
+		b, err := re.ReplaceAllSubmatchFunc([]byte(src), tc.n, func(match []int) ([]byte, error) {
+			groups := re.FindStringSubmatch(src[match[0]:])
+			s, err := swap(groups, match)
+			return []byte(s), err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if g := string(b); g != tc.exp {
+			t.Errorf("#%d: got %q exp %q", i, g, tc.exp)
+		}
+	}
+
+	calls := 0
+	fail := fmt.Errorf("fail")
+	g, err := re.ReplaceAllStringSubmatchFunc(src, -1, func(groups []string, match []int) (string, error) {
+		if calls++; groups[2] == "" {
+			return "", fail
+		}
+
+		return "x", nil
+	})
+	if g != "" || err != fail || calls != 2 {
+		t.Errorf("got %q, %v after %d calls", g, err, calls)
+	}
+
+	calls = 0
+	b, err := re.ReplaceAllSubmatchFunc([]byte(src), -1, func(match []int) ([]byte, error) {
+		if calls++; match[4] < 0 {
+			return nil, fail
+		}
+
+		return []byte("x"), nil
+	})
+	if b != nil || err != fail || calls != 2 {
+		t.Errorf("got %q, %v after %d calls", b, err, calls)
+	}
+}
+
+func TestAppendIndex(t *testing.T) {
+	for _, test := range findTests {
+		re := MustCompile(test.pat)
+		if e, g := re.FindSubmatchIndex([]byte(test.text)), re.AppendSubmatchIndex([]int{42}, []byte(test.text)); !reflect.DeepEqual(append([]int{42}, e...), g) {
+			t.Errorf("%q %q: got %v exp %v", test.pat, test.text, g, e)
+		}
+
+		if e, g := re.FindStringSubmatchIndex(test.text), re.AppendStringSubmatchIndex(nil, test.text); !reflect.DeepEqual(e, g) {
+			t.Errorf("%q %q: got %v exp %v", test.pat, test.text, g, e)
+		}
+
+		if e, g := re.FindIndex([]byte(test.text)), re.AppendIndex(nil, []byte(test.text)); !reflect.DeepEqual(e, g) {
+			t.Errorf("%q %q: got %v exp %v", test.pat, test.text, g, e)
+		}
+
+		if e, g := re.FindStringIndex(test.text), re.AppendStringIndex(nil, test.text); !reflect.DeepEqual(e, g) {
+			t.Errorf("%q %q: got %v exp %v", test.pat, test.text, g, e)
+		}
+
+		var all [][]int
+		re.FindAllSubmatchIndexFunc([]byte(test.text), func(loc []int) bool {
+			all = append(all, append([]int(nil), loc...))
+			return true
+		})
+		if e := re.FindAllSubmatchIndex([]byte(test.text), -1); !reflect.DeepEqual(e, all) {
+			t.Errorf("%q %q: got %v exp %v", test.pat, test.text, all, e)
+		}
+
+		all = nil
+		re.FindAllStringSubmatchIndexFunc(test.text, func(loc []int) bool {
+			all = append(all, append([]int(nil), loc...))
+			return true
+		})
+		if e := re.FindAllStringSubmatchIndex(test.text, -1); !reflect.DeepEqual(e, all) {
+			t.Errorf("%q %q: got %v exp %v", test.pat, test.text, all, e)
+		}
+
+		all = nil
+		re.FindAllIndexFunc([]byte(test.text), func(loc []int) bool {
+			all = append(all, append([]int(nil), loc...))
+			return true
+		})
+		if e := re.FindAllIndex([]byte(test.text), -1); !reflect.DeepEqual(e, all) {
+			t.Errorf("%q %q: got %v exp %v", test.pat, test.text, all, e)
+		}
+
+		all = nil
+		re.FindAllStringIndexFunc(test.text, func(loc []int) bool {
+			all = append(all, append([]int(nil), loc...))
+			return len(all) < 2
+		})
+		if e := re.FindAllStringIndex(test.text, 2); !reflect.DeepEqual(e, all) {
+			t.Errorf("%q %q: got %v exp %v", test.pat, test.text, all, e)
+		}
+	}
+
+	re := MustCompile(`([a-z]+)@([a-z]+)[.](com|org)`)
+	src := []byte(strings.Repeat("foo x@example.com bar abc@def.org 日本語\n", 10))
+	dst := make([]int, 0, 10)
+	re.AppendSubmatchIndex(dst, src)
+	if n := testing.AllocsPerRun(100, func() { dst = re.AppendSubmatchIndex(dst[:0], src) }); n != 0 && !raceEnabled {
+		t.Errorf("got %v allocations", n)
+	}
+
+	if g, e := dst, []int{4, 17, 4, 5, 6, 13, 14, 17}; !reflect.DeepEqual(g, e) {
+		t.Errorf("got %v exp %v", g, e)
+	}
+
+	var sum, cnt int
+	f := func(loc []int) bool {
+		sum += loc[7] - loc[6]
+		cnt++
+		return true
+	}
+	re.FindAllSubmatchIndexFunc(src, f)
+	if n := testing.AllocsPerRun(100, func() { re.FindAllSubmatchIndexFunc(src, f) }); n != 0 && !raceEnabled {
+		t.Errorf("got %v allocations", n)
+	}
+
+	if g, e := sum/cnt, 3; g != e {
+		t.Errorf("got %v exp %v", g, e)
+	}
+}
+
+func TestFindAt(t *testing.T) {
+	for i, tc := range []struct {
+		pat        string
+		src        string
+		start, end int
+		exp        []int
+	}{
+		{`\b`, "xfoo", 1, 4, []int{4, 4}},
+		{`\B`, "xfoo", 1, 4, []int{1, 1}},
+		{`\b`, "x foo", 2, 5, []int{2, 2}},
+		{`foo\b`, "foox", 0, 3, []int{0, 3}},
+		{`^foo`, "foofoo", 3, 6, nil},
+		{`\Afoo`, "foofoo", 3, 6, nil},
+		{`foo$`, "foox", 0, 3, []int{0, 3}},
+		{`(a+)(b)?`, "xaab aa", 2, 6, []int{2, 4, 2, 3, 3, 4}},
+		{`(a+)(b)?`, "xaab aa", 4, 7, []int{5, 7, 5, 7, -1, -1}},
+		{`日本`, "日本日本", 3, 12, []int{6, 12}},
+		{`x*`, "abc", 3, 3, []int{3, 3}},
+		{`[a-z]+@[a-z]+[.]com`, "a@b.com xyz@def.com", 1, 19, []int{8, 19}},
+	} {
+		re := MustCompile(tc.pat)
+		if g := re.FindStringSubmatchIndexAt(tc.src, tc.start, tc.end); !reflect.DeepEqual(g, tc.exp) {
+			t.Errorf("#%d: %q %q [%d:%d]: got %v exp %v", i, tc.pat, tc.src, tc.start, tc.end, g, tc.exp)
+		}
+
+		if g := re.FindSubmatchIndexAt([]byte(tc.src), tc.start, tc.end); !reflect.DeepEqual(g, tc.exp) {
+			t.Errorf("#%d: %q %q [%d:%d]: got %v exp %v", i, tc.pat, tc.src, tc.start, tc.end, g, tc.exp)
+		}
+
+		var e []int
+		if tc.exp != nil {
+			e = tc.exp[:2]
+		}
+		if g := re.FindStringIndexAt(tc.src, tc.start, tc.end); !reflect.DeepEqual(g, e) {
+			t.Errorf("#%d: %q %q [%d:%d]: got %v exp %v", i, tc.pat, tc.src, tc.start, tc.end, g, e)
+		}
+
+		if g := re.FindIndexAt([]byte(tc.src), tc.start, tc.end); !reflect.DeepEqual(g, e) {
+			t.Errorf("#%d: %q %q [%d:%d]: got %v exp %v", i, tc.pat, tc.src, tc.start, tc.end, g, e)
+		}
+	}
+
+	// The whole input gives the same results as the functions without bounds.
+	for _, test := range findTests {
+		re := MustCompile(test.pat)
+		n := len(test.text)
+		if g, e := re.FindAllStringSubmatchIndexAt(test.text, 0, n, -1), re.FindAllStringSubmatchIndex(test.text, -1); !reflect.DeepEqual(g, e) {
+			t.Errorf("%q %q: got %v exp %v", test.pat, test.text, g, e)
+		}
+
+		if g, e := re.FindAllSubmatchIndexAt([]byte(test.text), 0, n, -1), re.FindAllSubmatchIndex([]byte(test.text), -1); !reflect.DeepEqual(g, e) {
+			t.Errorf("%q %q: got %v exp %v", test.pat, test.text, g, e)
+		}
+
+		if g, e := re.FindAllStringIndexAt(test.text, 0, n, 2), re.FindAllStringIndex(test.text, 2); !reflect.DeepEqual(g, e) {
+			t.Errorf("%q %q: got %v exp %v", test.pat, test.text, g, e)
+		}
+
+		if g, e := re.FindAllIndexAt([]byte(test.text), 0, n, -1), re.FindAllIndex([]byte(test.text), -1); !reflect.DeepEqual(g, e) {
+			t.Errorf("%q %q: got %v exp %v", test.pat, test.text, g, e)
+		}
+	}
+
+	if g, e := MustCompile(`\B`).FindAllStringIndexAt("xfoo foo", 1, 8, -1), [][]int{{1, 1}, {2, 2}, {3, 3}, {6, 6}, {7, 7}}; !reflect.DeepEqual(g, e) {
+		t.Errorf("got %v exp %v", g, e)
+	}
+
+	re := MustCompile(`[a-z]+`)
+	if g, e := re.FindAllStringIndexAt("abc def ghi", 1, 9, -1), [][]int{{1, 3}, {4, 7}, {8, 9}}; !reflect.DeepEqual(g, e) {
+		t.Errorf("got %v exp %v", g, e)
+	}
+
+	for _, v := range [][2]int{{-1, 2}, {2, 1}, {0, 12}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%v: expected panic", v)
+				}
+			}()
+
+			re.FindStringIndexAt("abc def ghi", v[0], v[1])
+		}()
+	}
+}
+
+func TestMatchFullPrefix(t *testing.T) {
+	for i, v := range simpleTests {
+		re := MustCompile(v.re)
+		re2 := regexp.MustCompile(`^(?:` + v.re + `)$`)
+		if g, e := re.MatchFullString(v.src), re2.MatchString(v.src); g != e {
+			t.Errorf("%d: `%s` `%s` got %v exp %v", i, v.re, v.src, g, e)
+		}
+
+		if g, e := re.MatchFull([]byte(v.src)), re2.MatchString(v.src); g != e {
+			t.Errorf("%d: `%s` `%s` got %v exp %v", i, v.re, v.src, g, e)
+		}
+
+		e := -1
+		if loc := MustCompile(`^(?:` + v.re + `)`).FindStringIndex(v.src); loc != nil {
+			e = loc[1]
+		}
+		if g := re.MatchPrefixString(v.src); g != e {
+			t.Errorf("%d: `%s` `%s` got %v exp %v", i, v.re, v.src, g, e)
+		}
+
+		if g := re.MatchPrefix([]byte(v.src)); g != e {
+			t.Errorf("%d: `%s` `%s` got %v exp %v", i, v.re, v.src, g, e)
+		}
+	}
+
+	for i, v := range []struct {
+		re     string
+		src    string
+		full   bool
+		prefix int
+	}{
+		{`a|ab`, "ab", true, 1},
+		{`ab|a`, "ab", true, 2},
+		{`[ab]*`, "abcab", false, 2},
+		{`[a-z]+`, "abc1", false, 3},
+		{`[0-9]+`, "abc1", false, -1},
+		{`x*`, "", true, 0},
+		{`foo$`, "foo", true, 3},
+		{`foo$`, "foox", false, -1},
+		{`(a+)(b+)?`, "aab", true, 3},
+		{`[a-z]+@[a-z]+[.]com`, "x@example.com", true, 13},
+		{`[a-z]+@[a-z]+[.]com`, "x@example.com ", false, 13},
+		{`日本`, "日本語", false, 6},
+	} {
+		re := MustCompile(v.re)
+		if g, e := re.MatchFullString(v.src), v.full; g != e {
+			t.Errorf("#%d: `%s` `%s` got %v exp %v", i, v.re, v.src, g, e)
+		}
+
+		if g, e := re.MatchPrefixString(v.src), v.prefix; g != e {
+			t.Errorf("#%d: `%s` `%s` got %v exp %v", i, v.re, v.src, g, e)
+		}
+	}
+
+	// No recompilation, the literal prefix is kept.
+	re := MustCompile(`abc[0-9]+`)
+	if g, e := re.LiteralPrefix(); g != "abc" || e {
+		t.Errorf("got %q %v", g, e)
+	}
+
+	if !re.MatchFullString("abc123") || re.MatchFullString("abc123x") {
+		t.Error("unexpected result")
+	}
+
+	src := "abc123"
+	re.MatchFullString(src)
+	if n := testing.AllocsPerRun(100, func() { re.MatchFullString(src) }); n != 0 && !raceEnabled {
+		t.Errorf("got %v allocations", n)
+	}
+}
+
+// endlessReader returns an endless sequence of c.
+type endlessReader rune
+
+func (r endlessReader) ReadRune() (rune, int, error) { return rune(r), utf8.RuneLen(rune(r)), nil }
+
+func TestContext(t *testing.T) {
+	ctx := context.Background()
+	for _, test := range findTests {
+		re := MustCompile(test.pat)
+		g, err := re.FindAllStringSubmatchIndexContext(ctx, test.text, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if e := re.FindAllStringSubmatchIndex(test.text, -1); !reflect.DeepEqual(g, e) {
+			t.Errorf("%q %q: got %v exp %v", test.pat, test.text, g, e)
+		}
+
+		g, err = re.FindAllIndexContext(ctx, []byte(test.text), -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if e := re.FindAllIndex([]byte(test.text), -1); !reflect.DeepEqual(g, e) {
+			t.Errorf("%q %q: got %v exp %v", test.pat, test.text, g, e)
+		}
+
+		a, err := re.FindReaderSubmatchIndexContext(ctx, strings.NewReader(test.text))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if e := re.FindStringSubmatchIndex(test.text); !reflect.DeepEqual(a, e) {
+			t.Errorf("%q %q: got %v exp %v", test.pat, test.text, a, e)
+		}
+
+		m, err := re.MatchStringContext(ctx, test.text)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if e := re.MatchString(test.text); m != e {
+			t.Errorf("%q %q: got %v exp %v", test.pat, test.text, m, e)
+		}
+	}
+
+	// Budget.
+	re := MustCompile(`[a-z]+@[a-z]+`)
+	src := strings.Repeat("a@b ", 100)
+	g, err := re.WithBudget(10).FindAllStringIndexContext(ctx, src, -1)
+	var le *LimitError
+	if !errors.As(err, &le) || le.Err != ErrBudget || !errors.Is(err, ErrBudget) {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if e := [][]int{{0, 3}, {4, 7}}; !reflect.DeepEqual(g, e) || le.Pos < 7 || le.Pos >= 12 {
+		t.Errorf("got %v, %v exp %v", g, le.Pos, e)
+	}
+
+	if _, err := re.WithBudget(2*int64(len(src))).FindAllStringIndexContext(ctx, src, -1); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := re.FindAllStringIndexContext(ctx, src, -1); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := MustCompile(`x`).WithBudget(1000).MatchReaderContext(ctx, endlessReader('a')); !errors.Is(err, ErrBudget) {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	// Cancellation.
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if m, err := re.MatchStringContext(cctx, src); m || !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, %v", m, err)
+	}
+
+	dctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	a, err := MustCompile(`(x)y`).FindReaderSubmatchIndexContext(dctx, endlessReader('日'))
+	if a != nil || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, %v", a, err)
+	}
+
+	if !errors.As(err, &le) || le.Pos <= 0 || le.Pos%3 != 0 {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	if g, e := (&LimitError{Err: ErrBudget, Pos: 42}).Error(), "regexp: search stopped at offset 42: step budget exceeded"; g != e {
+		t.Errorf("got %q exp %q", g, e)
+	}
+}
+
+func TestMatchPartial(t *testing.T) {
+	for i, v := range simpleTests {
+		re := MustCompile(v.re)
+		full, _ := re.MatchPartialString(v.src)
+		if g, e := full, re.MatchFullString(v.src); g != e {
+			t.Errorf("%d: `%s` `%s` got %v exp %v", i, v.re, v.src, g, e)
+		}
+
+		full, _ = re.MatchPartialUnanchoredString(v.src)
+		if g, e := full, re.MatchString(v.src); g != e {
+			t.Errorf("%d: `%s` `%s` got %v exp %v", i, v.re, v.src, g, e)
+		}
+
+		// Every prefix of a full match is a partial match.
+		if !re.MatchFullString(v.src) {
+			continue
+		}
+
+		for j := range v.src {
+			if _, prefix := re.MatchPartialString(v.src[:j]); !prefix {
+				t.Errorf("%d: `%s` `%s`: expected partial match", i, v.re, v.src[:j])
+			}
+		}
+	}
+
+	for i, v := range []struct {
+		re, src                              string
+		full, prefix, fullAnywhere, anywhere bool
+	}{
+		{`[0-9]{3}-[0-9]{4}`, "", false, true, false, false},
+		{`[0-9]{3}-[0-9]{4}`, "555-", false, true, false, true},
+		{`[0-9]{3}-[0-9]{4}`, "555-1234", true, false, true, true},
+		{`[0-9]{3}-[0-9]{4}`, "555-12", false, true, false, true},
+		{`[0-9]{3}-[0-9]{4}`, "555-12a", false, false, false, false},
+		{`[0-9]{3}-[0-9]{4}`, "555-12345", false, false, true, true},
+		{`[0-9]{3}-[0-9]{4}`, "55a", false, false, false, false},
+		{`[0-9]{3}-[0-9]{4}`, "x55", false, false, false, true},
+		{`[a-z]+`, "abc", true, true, true, true},
+		{`abc`, "abc", true, false, true, false},
+		{`abc$`, "xab", false, false, false, true},
+		{`foo[.]example[.]com`, "foo.exa", false, true, false, true},
+		{`^abc`, "xab", false, false, false, false},
+		{`日本語`, "日本", false, true, false, true},
+		{`foo\Bx`, "foo", false, true, false, true},
+		{`foo\b x`, "foo", false, true, false, true},
+		{`foo\b`, "foo", true, false, true, false},
+		{`(?m)foo$\nx`, "foo", false, true, false, true},
+		{`a\zb`, "a", false, false, false, false},
+	} {
+		re := MustCompile(v.re)
+		full, prefix := re.MatchPartialString(v.src)
+		if full != v.full || prefix != v.prefix {
+			t.Errorf("#%d: `%s` `%s` got %v %v exp %v %v", i, v.re, v.src, full, prefix, v.full, v.prefix)
+		}
+
+		full, prefix = re.MatchPartial([]byte(v.src))
+		if full != v.full || prefix != v.prefix {
+			t.Errorf("#%d: `%s` `%s` got %v %v exp %v %v", i, v.re, v.src, full, prefix, v.full, v.prefix)
+		}
+
+		full, prefix = re.MatchPartialUnanchoredString(v.src)
+		if full != v.fullAnywhere || prefix != v.anywhere {
+			t.Errorf("#%d: `%s` `%s` got %v %v exp %v %v (unanchored)", i, v.re, v.src, full, prefix, v.fullAnywhere, v.anywhere)
+		}
+
+		full, prefix = re.MatchPartialUnanchored([]byte(v.src))
+		if full != v.fullAnywhere || prefix != v.anywhere {
+			t.Errorf("#%d: `%s` `%s` got %v %v exp %v %v (unanchored)", i, v.re, v.src, full, prefix, v.fullAnywhere, v.anywhere)
+		}
+	}
+}
+
+func TestSubmatchMemory(t *testing.T) {
+	const n = 1 << 16
+	re := MustCompile(`(a)(a)*b`)
+	s := strings.Repeat("a", n) + "b"
+	var ms runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&ms)
+	heap := int64(ms.HeapAlloc)
+	a := re.FindStringSubmatchIndex(s)
+	runtime.GC()
+	runtime.ReadMemStats(&ms)
+	if g, e := a, []int{0, n + 1, 0, 1, n - 1, n}; !reflect.DeepEqual(g, e) {
+		t.Fatalf("got %v exp %v", g, e)
+	}
+
+	if g := int64(ms.HeapAlloc) - heap; g > n {
+		t.Errorf("%v bytes retained", g)
+	}
+
+	vm := getVM(re, nil, s, false)
+	vm.find()
+	if g := len(vm.arena) + len(vm.arena2); g > 1<<12 {
+		t.Errorf("arenas of %v ints", g)
+	}
+
+	vm.put()
+	for _, re := range []*Regexp{re, MustCompile(`(a+)$`)} {
+		dst := re.AppendStringSubmatchIndex(nil, s)
+		if n := testing.AllocsPerRun(10, func() { dst = re.AppendStringSubmatchIndex(dst[:0], s) }); n != 0 && !raceEnabled {
+			t.Errorf("`%s`: got %v allocations", re, n)
+		}
+	}
+}
@@ -89,6 +89,11 @@ func matchTest(t *testing.T, test *FindTest) {
 	if m != (len(test.matches) > 0) {
 		t.Errorf("Match failure on %s: %t should be %t", test, m, len(test.matches) > 0)
 	}
+	// now try a reader
+	m = re.MatchReader(strings.NewReader(test.text))
+	if m != (len(test.matches) > 0) {
+		t.Errorf("MatchReader failure on %s: %t should be %t", test, m, len(test.matches) > 0)
+	}
 }
 
 func TestMatch(t *testing.T) {
@@ -113,6 +118,24 @@ func TestMatchFunction(t *testing.T) {
 	}
 }
 
+func TestMatchFunctions(t *testing.T) {
+	for _, test := range findTests {
+		e := len(test.matches) > 0
+		if m, err := Match(test.pat, []byte(test.text)); err != nil || m != e {
+			t.Errorf("Match failure on %s: %t, %v should be %t", &test, m, err, e)
+		}
+		if m, err := MatchReader(test.pat, strings.NewReader(test.text)); err != nil || m != e {
+			t.Errorf("MatchReader failure on %s: %t, %v should be %t", &test, m, err, e)
+		}
+	}
+	if _, err := Match(`a(b`, nil); err == nil {
+		t.Error("Match: expected error")
+	}
+	if _, err := MatchReader(`a(b`, strings.NewReader("")); err == nil {
+		t.Error("MatchReader: expected error")
+	}
+}
+
 func copyMatchTest(t *testing.T, test *FindTest) {
 	re := compileTest(t, test.pat, "")
 	if re == nil {
@@ -435,24 +458,32 @@ func TestLiteralPrefix(t *testing.T) {
 	}
 }
 
+type subexpIndex struct {
+	name  string
+	index int
+}
+
 type subexpCase struct {
-	input string
-	num   int
-	names []string
+	input   string
+	num     int
+	names   []string
+	indices []subexpIndex
 }
 
+var emptySubexpIndices = []subexpIndex{{"", -1}, {"missing", -1}}
+
 var subexpCases = []subexpCase{
-	{``, 0, nil},
-	{`.*`, 0, nil},
-	{`abba`, 0, nil},
-	{`ab(b)a`, 1, []string{"", ""}},
-	{`ab(.*)a`, 1, []string{"", ""}},
-	{`(.*)ab(.*)a`, 2, []string{"", "", ""}},
-	{`(.*)(ab)(.*)a`, 3, []string{"", "", "", ""}},
-	{`(.*)((a)b)(.*)a`, 4, []string{"", "", "", "", ""}},
-	{`(.*)(\(ab)(.*)a`, 3, []string{"", "", "", ""}},
-	{`(.*)(\(a\)b)(.*)a`, 3, []string{"", "", "", ""}},
-	{`(?P<foo>.*)(?P<bar>(a)b)(?P<foo>.*)a`, 4, []string{"", "foo", "bar", "", "foo"}},
+	{``, 0, nil, emptySubexpIndices},
+	{`.*`, 0, nil, emptySubexpIndices},
+	{`abba`, 0, nil, emptySubexpIndices},
+	{`ab(b)a`, 1, []string{"", ""}, emptySubexpIndices},
+	{`ab(.*)a`, 1, []string{"", ""}, emptySubexpIndices},
+	{`(.*)ab(.*)a`, 2, []string{"", "", ""}, emptySubexpIndices},
+	{`(.*)(ab)(.*)a`, 3, []string{"", "", "", ""}, emptySubexpIndices},
+	{`(.*)((a)b)(.*)a`, 4, []string{"", "", "", "", ""}, emptySubexpIndices},
+	{`(.*)(\(ab)(.*)a`, 3, []string{"", "", "", ""}, emptySubexpIndices},
+	{`(.*)(\(a\)b)(.*)a`, 3, []string{"", "", "", ""}, emptySubexpIndices},
+	{`(?P<foo>.*)(?P<bar>(a)b)(?P<foo>.*)a`, 4, []string{"", "foo", "bar", "", "foo"}, []subexpIndex{{"", -1}, {"missing", -1}, {"foo", 1}, {"bar", 2}}},
 }
 
 func TestSubexp(t *testing.T) {
@@ -475,6 +506,12 @@ func TestSubexp(t *testing.T) {
 				}
 			}
 		}
+		for _, subexp := range c.indices {
+			index := re.SubexpIndex(subexp.name)
+			if index != subexp.index {
+				t.Errorf("%q: SubexpIndex(%q) = %d, want %d", c.input, subexp.name, index, subexp.index)
+			}
+		}
 	}
 }
 
@@ -0,0 +1,73 @@
+// Copyright 2017 The Regexp Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package regexp
+
+// The functions in this file write their results to buffers of the caller or
+// pass them to callbacks. They reuse the internal state of the search across
+// calls, so a search of a []byte or string input does not allocate after the
+// first few calls.
+
+// AppendIndex appends to dst the two-element location of the leftmost match in
+// b of the regular expression, as returned by FindIndex, and returns the
+// result. If there is no match, dst is returned unchanged.
+func (re *Regexp) AppendIndex(dst []int, b []byte) []int {
+	return re.append(dst, getVM(re, b, "", true), 2)
+}
+
+// AppendStringIndex is like AppendIndex but searches s.
+func (re *Regexp) AppendStringIndex(dst []int, s string) []int {
+	return re.append(dst, getVM(re, nil, s, false), 2)
+}
+
+// AppendSubmatchIndex appends to dst the index pairs of the leftmost match in
+// b of the regular expression and of its subexpressions, as returned by
+// FindSubmatchIndex, and returns the result. If there is no match, dst is
+// returned unchanged.
+func (re *Regexp) AppendSubmatchIndex(dst []int, b []byte) []int {
+	return re.append(dst, getVM(re, b, "", true), 2*re.groups)
+}
+
+// AppendStringSubmatchIndex is like AppendSubmatchIndex but searches s.
+func (re *Regexp) AppendStringSubmatchIndex(dst []int, s string) []int {
+	return re.append(dst, getVM(re, nil, s, false), 2*re.groups)
+}
+
+func (re *Regexp) append(dst []int, vm *vm, n int) []int {
+	if a := vm.find(); a != nil {
+		dst = append(dst, a[:n]...)
+	}
+	vm.put()
+	return dst
+}
+
+// FindAllIndexFunc calls f with the two-element location of every successive
+// match in b of the regular expression, as defined by the 'All' description
+// in the package comment, until f returns false. The loc slice is valid only
+// until f returns.
+func (re *Regexp) FindAllIndexFunc(b []byte, f func(loc []int) bool) {
+	re.findAllFunc(getVM(re, b, "", true), 2, f)
+}
+
+// FindAllStringIndexFunc is like FindAllIndexFunc but searches s.
+func (re *Regexp) FindAllStringIndexFunc(s string, f func(loc []int) bool) {
+	re.findAllFunc(getVM(re, nil, s, false), 2, f)
+}
+
+// FindAllSubmatchIndexFunc is like FindAllIndexFunc but f gets the index pairs
+// of the match and of its subexpressions, as returned by FindSubmatchIndex.
+func (re *Regexp) FindAllSubmatchIndexFunc(b []byte, f func(loc []int) bool) {
+	re.findAllFunc(getVM(re, b, "", true), 2*re.groups, f)
+}
+
+// FindAllStringSubmatchIndexFunc is like FindAllSubmatchIndexFunc but searches
+// s.
+func (re *Regexp) FindAllStringSubmatchIndexFunc(s string, f func(loc []int) bool) {
+	re.findAllFunc(getVM(re, nil, s, false), 2*re.groups, f)
+}
+
+func (re *Regexp) findAllFunc(vm *vm, n int, f func([]int) bool) {
+	allMatches(vm, -1, func(a []int) bool { return f(a[:n:n]) })
+	vm.put()
+}
@@ -0,0 +1,86 @@
+// Copyright 2017 The Regexp Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package regexp
+
+import (
+	"fmt"
+)
+
+// The functions in this file search only the part of their input between
+// byte offsets start and end, which must satisfy 0 <= start <= end <= the
+// length of the input. Unlike searching a slice of the input, the text before
+// start is the context of the search, so for example \b and \B see the rune
+// preceding start, while ^ and \A do not match at start > 0. The
+// search stops at end, which is the end of text for $ and \z. The locations
+// reported are offsets in the whole input.
+
+func checkBounds(start, end, n int) {
+	if start < 0 || start > end || end > n {
+		panic(fmt.Sprintf("regexp: search bounds [%d:%d] out of range [0:%d]", start, end, n))
+	}
+}
+
+func newBytesVMAt(re *Regexp, b []byte, start, end int) *vm {
+	checkBounds(start, end, len(b))
+	vm := newBytesVM(re, b[:end])
+	vm.skip(start)
+	return vm
+}
+
+func newStringVMAt(re *Regexp, s string, start, end int) *vm {
+	checkBounds(start, end, len(s))
+	vm := newStringVM(re, s[:end])
+	vm.skip(start)
+	return vm
+}
+
+// FindIndexAt is like FindIndex but searches b[start:end].
+func (re *Regexp) FindIndexAt(b []byte, start, end int) (loc []int) {
+	if loc = newBytesVMAt(re, b, start, end).find(); loc != nil {
+		loc = loc[:2]
+	}
+	return loc
+}
+
+// FindStringIndexAt is like FindStringIndex but searches s[start:end].
+func (re *Regexp) FindStringIndexAt(s string, start, end int) (loc []int) {
+	if loc = newStringVMAt(re, s, start, end).find(); loc != nil {
+		loc = loc[:2]
+	}
+	return loc
+}
+
+// FindSubmatchIndexAt is like FindSubmatchIndex but searches b[start:end].
+func (re *Regexp) FindSubmatchIndexAt(b []byte, start, end int) []int {
+	return newBytesVMAt(re, b, start, end).find()
+}
+
+// FindStringSubmatchIndexAt is like FindStringSubmatchIndex but searches
+// s[start:end].
+func (re *Regexp) FindStringSubmatchIndexAt(s string, start, end int) []int {
+	return newStringVMAt(re, s, start, end).find()
+}
+
+// FindAllIndexAt is like FindAllIndex but searches b[start:end].
+func (re *Regexp) FindAllIndexAt(b []byte, start, end, n int) [][]int {
+	return re.findAllIndex(newBytesVMAt(re, b, start, end), n)
+}
+
+// FindAllStringIndexAt is like FindAllStringIndex but searches s[start:end].
+func (re *Regexp) FindAllStringIndexAt(s string, start, end, n int) [][]int {
+	return re.findAllIndex(newStringVMAt(re, s, start, end), n)
+}
+
+// FindAllSubmatchIndexAt is like FindAllSubmatchIndex but searches
+// b[start:end].
+func (re *Regexp) FindAllSubmatchIndexAt(b []byte, start, end, n int) [][]int {
+	return re.findAllSubmatchIndex(newBytesVMAt(re, b, start, end), n)
+}
+
+// FindAllStringSubmatchIndexAt is like FindAllStringSubmatchIndex but searches
+// s[start:end].
+func (re *Regexp) FindAllStringSubmatchIndexAt(s string, start, end, n int) [][]int {
+	return re.findAllSubmatchIndex(newStringVMAt(re, s, start, end), n)
+}
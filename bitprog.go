@@ -0,0 +1,272 @@
+// Copyright 2017 The Regexp Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package regexp
+
+import (
+	"math/bits"
+	"sync"
+	"unicode/utf8"
+)
+
+const maxBitPositions = 64
+
+// bitProg is a bit parallel simulation of the Glushkov automaton of a program
+// having at most maxBitPositions rune consuming states, called positions, and
+// no assertions other than \A. A set of positions is an uint64.
+type bitProg struct {
+	ascii  [utf8.RuneSelf]uint64 // Positions consuming an ASCII rune.
+	empty  bool                  // The empty string matches after the beginning of text.
+	empty0 bool                  // The empty string matches at the beginning of text.
+	final  uint64                // Positions after which a match can end.
+	first  uint64                // Positions that can consume the first rune of a match after the beginning of text.
+	first0 uint64                // Positions that can consume the first rune of a match at the beginning of text.
+	follow []uint64              // follow[i]: Positions that can follow position i.
+	next8  [][256]uint64         // next8[k][b]: Positions that can follow the positions 8k+i for bits i set in b.
+	once   sync.Once             // Computes next8 on first use.
+	ranges [][][2]rune           // Runes consumed by a position.
+	wide   uint64                // Positions consuming a rune >= utf8.RuneSelf.
+}
+
+// getBitProg computes re.bits if the program is small enough and no other
+// acceleration applies.
+func (re *Regexp) getBitProg() *Regexp {
+	if noOpt || re.prefilter != nil || re.reverse != nil {
+		return re
+	}
+
+	pos := make([]int, len(re.prog))
+	b := &bitProg{}
+	for _, s := range re.states() {
+		q := &re.prog[s]
+		switch q.kind {
+		case opAssert:
+			if q.arg != assertBOT {
+				return re
+			}
+		case
+			opChar,
+			opCharClass,
+			opDot,
+			opDotNL,
+			opNotCharClass:
+
+			if len(b.ranges) == maxBitPositions {
+				return re
+			}
+
+			if q.kind == opCharClass {
+				for i := q.arg; i < q.arg2; i += 2 {
+					switch -re.regs[i] {
+					case assertNotD, assertNotS, assertNotW:
+						// The VM lets these consume the end of text.
+						return re
+					}
+				}
+			}
+
+			r, ok := re.runeRanges(q)
+			if !ok {
+				return re
+			}
+
+			pos[s] = len(b.ranges)
+			b.ranges = append(b.ranges, r)
+		}
+	}
+
+	// closure returns the positions reachable from s without consuming
+	// input and whether the accept state is reachable as well. \A holds if
+	// bot is true.
+	seen := make([]int, len(re.prog))
+	gen := 0
+	var closure func(int, bool) (uint64, bool)
+	closure = func(s int, bot bool) (m uint64, accept bool) {
+		if seen[s] == gen {
+			return 0, false
+		}
+
+		seen[s] = gen
+		switch q := &re.prog[s]; q.kind {
+		case opAccept:
+			return 0, true
+		case opAssert:
+			if !bot {
+				return 0, false
+			}
+
+			return closure(q.out, bot)
+		case
+			opNop,
+			opSave:
+
+			return closure(q.out, bot)
+		case opSplit:
+			m, accept = closure(q.out, bot)
+			m1, accept1 := closure(q.out1, bot)
+			return m | m1, accept || accept1
+		default:
+			return 1 << uint(pos[s]), false
+		}
+	}
+	gen++
+	b.first0, b.empty0 = closure(re.start, true)
+	gen++
+	b.first, b.empty = closure(re.start, false)
+	follow := make([]uint64, len(b.ranges))
+	for _, s := range re.states() {
+		switch re.prog[s].kind {
+		case
+			opChar,
+			opCharClass,
+			opDot,
+			opDotNL,
+			opNotCharClass:
+
+			gen++
+			m, accept := closure(re.prog[s].out, false)
+			follow[pos[s]] = m
+			if accept {
+				b.final |= 1 << uint(pos[s])
+			}
+		}
+	}
+	b.tables(follow)
+	re.bits = b
+	return re
+}
+
+// tables sets b.follow and computes the lookup tables of b from b.ranges.
+func (b *bitProg) tables(follow []uint64) {
+	b.follow = follow
+	for i, r := range b.ranges {
+		for _, v := range r {
+			for c := v[0]; c <= v[1] && c < utf8.RuneSelf; c++ {
+				b.ascii[c] |= 1 << uint(i)
+			}
+		}
+		if n := len(r); n != 0 && r[n-1][1] >= utf8.RuneSelf {
+			b.wide |= 1 << uint(i)
+		}
+	}
+}
+
+// nextTable computes b.next8.
+func (b *bitProg) nextTable() {
+	b.next8 = make([][256]uint64, (len(b.follow)+7)/8)
+	for k := range b.next8 {
+		// The set v is the set v&(v-1) plus its lowest position.
+		for v := 1; v < 256; v++ {
+			m := b.next8[k][v&(v-1)]
+			if j := 8*k + bits.TrailingZeros8(uint8(v)); j < len(b.follow) {
+				m |= b.follow[j]
+			}
+			b.next8[k][v] = m
+		}
+	}
+}
+
+func inRanges(r [][2]rune, c rune) bool {
+	for _, v := range r {
+		if c < v[0] {
+			return false
+		}
+
+		if c <= v[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// next returns the positions that can follow the positions in m.
+func (b *bitProg) next(m uint64) (r uint64) {
+	for k := 0; m != 0; k, m = k+1, m>>8 {
+		r |= b.next8[k][m&0xff]
+	}
+	return r
+}
+
+// consume returns the positions in m consuming c.
+func (b *bitProg) consume(m uint64, c rune) uint64 {
+	if c < utf8.RuneSelf {
+		return b.ascii[c] & m
+	}
+
+	var r uint64
+	for w := m & b.wide; w != 0; w &= w - 1 {
+		if i := bits.TrailingZeros64(w); inRanges(b.ranges[i], c) {
+			r |= 1 << uint(i)
+		}
+	}
+	return r
+}
+
+// bitMatch is like match using re.bits, which must not be nil.
+func (vm *vm) bitMatch() bool {
+	b := vm.re.bits
+	b.once.Do(b.nextTable)
+	if b.empty || vm.first && b.empty0 {
+		return true
+	}
+
+	var m uint64 // Positions that consumed the last rune.
+	for ; vm.c >= 0; vm.next() {
+		n := b.next(m)
+		switch {
+		case vm.first:
+			n |= b.first0
+			vm.first = false
+		default:
+			n |= b.first
+		}
+		if n == 0 {
+			return false
+		}
+
+		if m = b.consume(n, vm.c); m&b.final != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// bitStart returns the position at or after vm.pos from where find can look
+// for the leftmost match, or -1 if there is no match. The VM must have a
+// string or []byte input. The position is the last one before the end of the
+// earliest match where no thread started before is alive, so no match can
+// start before it.
+func (vm *vm) bitStart() int {
+	b := vm.re.bits
+	b.once.Do(b.nextTable)
+	if b.empty || vm.first && b.empty0 {
+		return vm.pos
+	}
+
+	r := vm.pos
+	first := vm.first
+	var m uint64 // Positions that consumed the last rune.
+	for ; vm.c >= 0; vm.next() {
+		n := b.next(m)
+		if n == 0 {
+			r = vm.pos
+		}
+		switch {
+		case first:
+			n |= b.first0
+			first = false
+		default:
+			n |= b.first
+		}
+		if n == 0 {
+			return -1
+		}
+
+		if m = b.consume(n, vm.c); m&b.final != 0 {
+			vm.first = vm.first && vm.pos == 0
+			return r
+		}
+	}
+	return -1
+}
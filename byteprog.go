@@ -0,0 +1,393 @@
+// Copyright 2017 The Regexp Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package regexp
+
+import (
+	"sort"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// byteProg is a form of the program consuming UTF-8 encoded input one byte at
+// a time. Every rune consuming state is compiled to an automaton over byte
+// ranges of the UTF-8 encodings of the runes it accepts. Bytes not part of a
+// valid UTF-8 encoding never match, so the program is exact on valid UTF-8
+// input only.
+//
+// The program uses opAccept, opAssert, opByteRange, opSave and opSplit. The
+// arg and arg2 fields of opByteRange are the first and last byte class of the
+// range.
+type byteProg struct {
+	anchored bool
+	class    [256]uint8 // Byte equivalence classes.
+	context  bool       // Some assertions other than \A and \z are used.
+	nclass   int
+	prog     []instr
+	start    int // Full match.
+}
+
+// lazyByteProg is the byte form of a program, built on first use.
+type lazyByteProg struct {
+	once sync.Once
+	p    *byteProg
+}
+
+// byteProg returns the byte form of the program, or nil if some rune consuming
+// states depend on context.
+func (re *Regexp) byteProg() *byteProg {
+	if re.bprog == nil {
+		return re.newByteProg()
+	}
+
+	re.bprog.once.Do(func() { re.bprog.p = re.newByteProg() })
+	return re.bprog.p
+}
+
+// byteMatch reports whether match can use the byte form of the program. The
+// VM must have a string or []byte input, which must be valid UTF-8. The VM
+// evaluates assertions other than \A and \z differently, so the program must
+// not use them.
+func (vm *vm) byteMatch() bool {
+	if vm.r != nil || vm.limit != nil || vm.re.prefilter != nil {
+		return false
+	}
+
+	if p := vm.re.byteProg(); p == nil || p.context {
+		return false
+	}
+
+	if vm.isBytes {
+		return utf8.Valid(vm.bsrc)
+	}
+
+	return utf8.ValidString(vm.src)
+}
+
+func (re *Regexp) newByteProg() *byteProg {
+	if noOpt || re.raw {
+		return nil
+	}
+
+	// Allocate a state for every state of re reachable from re.start.
+	states := re.states()
+	idx := make([]int, len(re.prog))
+	p := &byteProg{anchored: re.anchored}
+	for _, s := range states {
+		idx[s] = len(p.prog)
+		p.prog = append(p.prog, instr{})
+	}
+	var bounds [257]bool
+	for _, s := range states {
+		q := re.prog[s]
+		switch q.kind {
+		case opAccept:
+			p.prog[idx[s]] = q
+		case opAssert:
+			if q.arg != assertBOT && q.arg != assertEOT {
+				p.context = true
+			}
+			q.out = idx[q.out]
+			p.prog[idx[s]] = q
+		case opSave:
+			q.out = idx[q.out]
+			p.prog[idx[s]] = q
+		case opNop:
+			p.prog[idx[s]] = instr{kind: opNop, out: idx[q.out]}
+		case opSplit:
+			q.out = idx[q.out]
+			q.out1 = idx[q.out1]
+			p.prog[idx[s]] = q
+		case
+			opChar,
+			opCharClass,
+			opDot,
+			opDotNL,
+			opNotCharClass:
+
+			ranges, ok := re.runeRanges(&q)
+			if !ok {
+				return nil
+			}
+
+			// Root of the automaton goes to idx[s].
+			p.prog[idx[s]] = p.utf8Automaton(ranges, idx[q.out])
+		default:
+			panic("internal error")
+		}
+	}
+
+	// Compute the byte classes and map ranges to them.
+	for i := range p.prog {
+		if q := &p.prog[i]; q.kind == opByteRange && q.arg <= q.arg2 {
+			bounds[q.arg] = true
+			bounds[q.arg2+1] = true
+		}
+	}
+	for b := 1; b < 256; b++ {
+		if bounds[b] {
+			p.nclass++
+		}
+		p.class[b] = uint8(p.nclass)
+	}
+	p.nclass++
+	for i := range p.prog {
+		if q := &p.prog[i]; q.kind == opByteRange && q.arg <= q.arg2 {
+			q.arg = int(p.class[q.arg])
+			q.arg2 = int(p.class[q.arg2])
+		}
+	}
+	p.start = idx[re.start]
+	return p
+}
+
+// runeRanges returns the sorted, non overlapping and non adjacent ranges of
+// runes consumed by q, or false if that depends on context.
+func (re *Regexp) runeRanges(q *instr) (r [][2]rune, ok bool) {
+	switch q.kind {
+	case opChar:
+		return [][2]rune{{rune(q.arg), rune(q.arg)}}, true
+	case opDot:
+		return [][2]rune{{0, '\n' - 1}, {'\n' + 1, unicode.MaxRune}}, true
+	case opDotNL:
+		return [][2]rune{{0, unicode.MaxRune}}, true
+	case
+		opCharClass,
+		opNotCharClass:
+
+		// nop
+	default:
+		panic("internal error")
+	}
+
+	for i := q.arg; i < q.arg2; i += 2 {
+		lo := re.regs[i]
+		if lo >= 0 {
+			r = append(r, [2]rune{rune(lo), rune(re.regs[i+1])})
+			continue
+		}
+
+		switch -lo {
+		case assertD, assertNotD, assertNotS, assertNotW, assertS, assertW:
+			// ok
+		default:
+			return nil, false
+		}
+
+		// The assertions hold for either all or none of the non ASCII
+		// runes.
+		f := asserts[-lo]
+		for c := rune(0); c <= utf8.RuneSelf; c++ {
+			if f(false, -1, c) {
+				hi := c
+				if c == utf8.RuneSelf {
+					hi = unicode.MaxRune
+				}
+				r = append(r, [2]rune{c, hi})
+			}
+		}
+	}
+	sort.Slice(r, func(i, j int) bool { return r[i][0] < r[j][0] })
+	w := 0
+	for _, v := range r {
+		if w != 0 && v[0] <= r[w-1][1]+1 {
+			if v[1] > r[w-1][1] {
+				r[w-1][1] = v[1]
+			}
+			continue
+		}
+
+		r[w] = v
+		w++
+	}
+	r = r[:w]
+	if q.kind == opCharClass {
+		return r, true
+	}
+
+	var n [][2]rune
+	c := rune(0)
+	for _, v := range r {
+		if v[0] > c {
+			n = append(n, [2]rune{c, v[0] - 1})
+		}
+		c = v[1] + 1
+	}
+	if c <= unicode.MaxRune {
+		n = append(n, [2]rune{c, unicode.MaxRune})
+	}
+	return n, true
+}
+
+// utf8Automaton adds states matching the UTF-8 encoding of any rune in ranges
+// and continuing to out. The root state is returned, not added.
+func (p *byteProg) utf8Automaton(ranges [][2]rune, out int) instr {
+	var seqs [][][2]byte
+	for _, v := range ranges {
+		seqs = utf8Sequences(seqs, v[0], v[1])
+	}
+	if len(seqs) == 0 {
+		// An empty range never matches.
+		return instr{kind: opByteRange, arg: 1, arg2: 0, out: out}
+	}
+
+	var roots []int
+	for _, seq := range seqs {
+		t := out
+		for i := len(seq) - 1; i >= 0; i-- {
+			p.prog = append(p.prog, instr{kind: opByteRange, arg: int(seq[i][0]), arg2: int(seq[i][1]), out: t})
+			t = len(p.prog) - 1
+		}
+		roots = append(roots, t)
+	}
+	for len(roots) > 1 {
+		n := len(roots)
+		p.prog = append(p.prog, instr{kind: opSplit, out: roots[n-2], out1: roots[n-1]})
+		roots = append(roots[:n-2], len(p.prog)-1)
+	}
+
+	// The root is the last added state.
+	root := p.prog[len(p.prog)-1]
+	p.prog = p.prog[:len(p.prog)-1]
+	return root
+}
+
+// utf8Sequences appends to dst the sequences of byte ranges matching exactly
+// the UTF-8 encodings of runes in [lo, hi], surrogates excluded.
+func utf8Sequences(dst [][][2]byte, lo, hi rune) [][][2]byte {
+	type rng struct{ lo, hi rune }
+	stack := []rng{{lo, hi}}
+	var a, b [utf8.UTFMax]byte
+loop:
+	for len(stack) != 0 {
+		r := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if r.lo > r.hi {
+			continue
+		}
+
+		// Exclude surrogates.
+		if r.lo <= 0xdfff && r.hi >= 0xd800 {
+			stack = append(stack, rng{0xe000, r.hi}, rng{r.lo, 0xd7ff})
+			continue
+		}
+
+		// Split at boundaries of the encoded length.
+		for _, m := range []rune{0x7f, 0x7ff, 0xffff} {
+			if r.lo <= m && m < r.hi {
+				stack = append(stack, rng{m + 1, r.hi}, rng{r.lo, m})
+				continue loop
+			}
+		}
+
+		// Split until only the last differing byte is not a full range.
+		if r.hi >= utf8.RuneSelf {
+			for i := uint(1); i < utf8.UTFMax; i++ {
+				m := rune(1)<<(6*i) - 1
+				if r.lo&^m == r.hi&^m {
+					continue
+				}
+
+				if r.lo&m != 0 {
+					stack = append(stack, rng{r.lo | m + 1, r.hi}, rng{r.lo, r.lo | m})
+					continue loop
+				}
+
+				if r.hi&m != m {
+					stack = append(stack, rng{r.hi &^ m, r.hi}, rng{r.lo, r.hi&^m - 1})
+					continue loop
+				}
+			}
+		}
+
+		n := utf8.EncodeRune(a[:], r.lo)
+		utf8.EncodeRune(b[:], r.hi)
+		seq := make([][2]byte, n)
+		for i := range seq {
+			seq[i] = [2]byte{a[i], b[i]}
+		}
+		dst = append(dst, seq)
+	}
+	return dst
+}
+
+// match reports whether the program matches bsrc, if not nil, or src.
+func (p *byteProg) match(bsrc []byte, src string) bool {
+	n := len(src)
+	if bsrc != nil {
+		n = len(bsrc)
+	}
+	clist := newThreadList(len(p.prog))
+	nlist := newThreadList(len(p.prog))
+	var add func(*threadList, int, int)
+	add = func(l *threadList, pc, pos int) {
+		if l.has(pc) {
+			return
+		}
+
+		l.include(thread{pc: pc})
+		switch q := &p.prog[pc]; q.kind {
+		case opAccept:
+			l.match = true
+		case opAssert:
+			last, c := rune(bot), rune(eof)
+			switch {
+			case bsrc != nil:
+				if pos != 0 {
+					last, _ = utf8.DecodeLastRune(bsrc[:pos])
+				}
+				if pos != n {
+					c, _ = utf8.DecodeRune(bsrc[pos:])
+				}
+			default:
+				if pos != 0 {
+					last, _ = utf8.DecodeLastRuneInString(src[:pos])
+				}
+				if pos != n {
+					c, _ = utf8.DecodeRuneInString(src[pos:])
+				}
+			}
+			if asserts[q.arg](pos == 0, last, c) {
+				add(l, q.out, pos)
+			}
+		case
+			opNop,
+			opSave:
+
+			add(l, q.out, pos)
+		case opSplit:
+			add(l, q.out, pos)
+			add(l, q.out1, pos)
+		}
+	}
+	for pos := 0; ; pos++ {
+		if pos == 0 || !p.anchored {
+			add(clist, p.start, pos)
+		}
+		if clist.match {
+			return true
+		}
+
+		if pos == n || clist.len == 0 && p.anchored {
+			return false
+		}
+
+		var b byte
+		switch {
+		case bsrc != nil:
+			b = bsrc[pos]
+		default:
+			b = src[pos]
+		}
+		k := int(p.class[b])
+		nlist.len = 0
+		for i := 0; i < clist.len; i++ {
+			if q := &p.prog[clist.dense[i].pc]; q.kind == opByteRange && k >= q.arg && k <= q.arg2 {
+				add(nlist, q.out, pos+1)
+			}
+		}
+		clist, nlist = nlist, clist
+	}
+}
@@ -0,0 +1,676 @@
+// Copyright 2017 The Regexp Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	"io"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"github.com/cznic/regexp"
+)
+
+const (
+	eof = -1 // Must match the generated runtime.
+	bot = -3
+)
+
+// generate writes to w the source code of a Go file of package pkg that
+// declares a type named typ and, for every i, a variable named names[i] of
+// type *typ matching like res[i]. The generated type has the Match and Find*
+// methods of Regexp as well as String, NumSubexp, SubexpNames and
+// LiteralPrefix. The generated code depends only on the standard library and
+// does no work at program initialization.
+//
+// The generated matchers do not use the prefilters and other accelerations
+// of a Regexp.
+func generate(w io.Writer, pkg, typ string, names []string, res []*regexp.Regexp) error {
+	if len(names) != len(res) {
+		return fmt.Errorf("%v names, %v regexps", len(names), len(res))
+	}
+
+	for _, v := range append([]string{pkg, typ}, names...) {
+		if !token.IsIdentifier(v) {
+			return fmt.Errorf("invalid identifier %q", v)
+		}
+	}
+
+	t := strings.ToLower(typ[:1]) + typ[1:]
+	if t == typ {
+		t = "new" + strings.ToUpper(typ[:1]) + typ[1:]
+	}
+	var b bytes.Buffer
+	data := map[string]string{"Pkg": pkg, "T": typ, "t": t}
+	if err := genHeader.Execute(&b, data); err != nil {
+		return err
+	}
+
+	progs := make([]*regexp.Prog, len(res))
+	b.WriteString("var (\n")
+	for i, re := range res {
+		p := re.Prog()
+		progs[i] = p
+		prefix, complete := re.LiteralPrefix()
+		fmt.Fprintf(&b, "\t// %s matches %s.\n", names[i], quote(re.String()))
+		fmt.Fprintf(&b, "\t%s = &%s{\n", names[i], typ)
+		fmt.Fprintf(&b, "\t\tadd: %sAdd%s,\n", t, names[i])
+		fmt.Fprintf(&b, "\t\tanchored: %v,\n", p.Anchored)
+		fmt.Fprintf(&b, "\t\tcomplete: %v,\n", complete)
+		fmt.Fprintf(&b, "\t\tgroups: %v,\n", re.NumSubexp()+1)
+		fmt.Fprintf(&b, "\t\tnames: %#v,\n", re.SubexpNames())
+		fmt.Fprintf(&b, "\t\tprefix: %q,\n", prefix)
+		fmt.Fprintf(&b, "\t\traw: %v,\n", p.Raw)
+		fmt.Fprintf(&b, "\t\tsize: %v,\n", len(p.Inst))
+		fmt.Fprintf(&b, "\t\tsrc: %q,\n", re.String())
+		fmt.Fprintf(&b, "\t\tstart: %v,\n", p.Start)
+		fmt.Fprintf(&b, "\t\tstart1: %v,\n", p.Start1)
+		fmt.Fprintf(&b, "\t\tstep: %sStep%s,\n", t, names[i])
+		b.WriteString("\t}\n")
+	}
+	b.WriteString(")\n")
+	for i, p := range progs {
+		genAdd(&b, p, t, names[i])
+		genStep(&b, p, t, names[i])
+	}
+	if err := genRuntime.Execute(&b, data); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(&b, "\nfunc %sAssert(k int, first bool, last, c rune) bool {\n\tswitch k {\n", t)
+	for _, k := range []regexp.Assertion{
+		regexp.AssertWordBoundary,
+		regexp.AssertBeginText,
+		regexp.AssertDigit,
+		regexp.AssertEndText,
+		regexp.AssertEndLine,
+		regexp.AssertNoWordBoundary,
+		regexp.AssertNotDigit,
+		regexp.AssertNotSpace,
+		regexp.AssertNotWord,
+		regexp.AssertSpace,
+		regexp.AssertWord,
+	} {
+		fmt.Fprintf(&b, "\tcase %d: // %s\n\t\treturn %s\n", k, k, strings.Replace(genAsserts[k], "%[1]s", t, -1))
+	}
+	b.WriteString("\t}\n\tpanic(\"internal error\")\n}\n")
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(src)
+	return err
+}
+
+// genAdd writes the function doing what the VM does when adding a thread.
+func genAdd(w *bytes.Buffer, p *regexp.Prog, t, name string) {
+	fmt.Fprintf(w, "\nfunc %sAdd%s(m *%sMachine, l *%sList, pc int, cap []int, pos int) {\n", t, name, t, t)
+	fmt.Fprintf(w, "\tfor !l.has(pc) {\n\t\tl.include(pc, cap)\n\t\tswitch pc {\n")
+	for pc := range p.Inst {
+		switch in := &p.Inst[pc]; in.Op {
+		case regexp.InstAccept:
+			fmt.Fprintf(w, "\t\tcase %d:\n\t\t\tm.accept(l, cap)\n\t\t\treturn\n", pc)
+		case regexp.InstAssert:
+			fmt.Fprintf(w, "\t\tcase %d: // %s\n\t\t\tif !%sAssert(%d, m.first, m.last, m.c) {\n\t\t\t\treturn\n\t\t\t}\n\n\t\t\tpc = %d\n", pc, regexp.Assertion(in.Arg), t, in.Arg, in.Out)
+		case regexp.InstNop:
+			fmt.Fprintf(w, "\t\tcase %d:\n\t\t\tpc = %d\n", pc, in.Out)
+		case regexp.InstSave:
+			fmt.Fprintf(w, "\t\tcase %d:\n\t\t\tcap = m.update(cap, %d, pos)\n\t\t\tpc = %d\n", pc, in.Arg, in.Out)
+		case regexp.InstSplit:
+			fmt.Fprintf(w, "\t\tcase %d:\n\t\t\t%sAdd%s(m, l, %d, cap, pos)\n\t\t\tpc = %d\n", pc, t, name, in.Out, in.Out1)
+		default:
+			// Rune consuming states.
+		}
+	}
+	fmt.Fprintf(w, "\t\tdefault:\n\t\t\treturn\n\t\t}\n\t}\n}\n")
+}
+
+// genStep writes the function returning the state following pc in a VM step,
+// or -1, and whether it consumes the current rune.
+func genStep(w *bytes.Buffer, p *regexp.Prog, t, name string) {
+	fmt.Fprintf(w, "\nfunc %sStep%s(m *%sMachine, pc int) (int, bool) {\n\tc := m.c\n\tswitch pc {\n", t, name, t)
+	used := false
+	for pc := range p.Inst {
+		var cond string
+		consume := true
+		switch in := &p.Inst[pc]; in.Op {
+		case regexp.InstAssert:
+			switch regexp.Assertion(in.Arg) {
+			case
+				regexp.AssertBeginText,
+				regexp.AssertEndLine,
+				regexp.AssertEndText,
+				regexp.AssertNoWordBoundary,
+				regexp.AssertWordBoundary:
+
+				cond = fmt.Sprintf("%sAssert(%d, m.first, m.last, c)", t, in.Arg)
+				consume = false
+			}
+		case regexp.InstRune:
+			cond = fmt.Sprintf("c == %s", genRune(rune(in.Arg)))
+		case regexp.InstClass:
+			cond = genSet(t, in.Ranges)
+		case regexp.InstAny:
+			cond = fmt.Sprintf("c != '\\n' && c != %d", eof)
+		case regexp.InstAnyNL:
+			cond = fmt.Sprintf("c != %d", eof)
+		case regexp.InstNotClass:
+			cond = fmt.Sprintf("!(%s) && c != %d", genSet(t, in.Ranges), eof)
+		}
+		if cond == "" {
+			continue
+		}
+
+		used = true
+		fmt.Fprintf(w, "\tcase %d:\n\t\tif %s {\n\t\t\treturn %d, %v\n\t\t}\n", pc, cond, p.Inst[pc].Out, consume)
+	}
+	if !used {
+		w.WriteString("\tdefault:\n\t\t_ = c\n")
+	}
+	w.WriteString("\t}\n\treturn -1, false\n}\n")
+}
+
+func genSet(t string, ranges []rune) string {
+	var a []string
+	for i := 0; i < len(ranges); i += 2 {
+		lo, hi := ranges[i], ranges[i+1]
+		switch {
+		case lo < 0:
+			a = append(a, fmt.Sprintf("%sAssert(%d, m.first, m.last, c)", t, -lo))
+		case lo == hi:
+			a = append(a, fmt.Sprintf("c == %s", genRune(lo)))
+		default:
+			a = append(a, fmt.Sprintf("c >= %s && c <= %s", genRune(lo), genRune(hi)))
+		}
+	}
+	if len(a) == 0 {
+		return "false"
+	}
+
+	return strings.Join(a, " || ")
+}
+
+func genRune(r rune) string {
+	if r != '\'' && r != '\\' && unicode.IsPrint(r) {
+		return strconv.QuoteRune(r)
+	}
+
+	return fmt.Sprintf("%#x", r)
+}
+
+// genAsserts are the bodies of the assertion functions, %[1]s is the prefix
+// of the runtime identifiers. They must be kept in sync with the asserts of
+// package regexp.
+var genAsserts = map[regexp.Assertion]string{
+	regexp.AssertBeginText:      "first",
+	regexp.AssertDigit:          "c >= '0' && c <= '9'",
+	regexp.AssertEndLine:        fmt.Sprintf("c == %d || c == '\\n'", eof),
+	regexp.AssertEndText:        fmt.Sprintf("c == %d", eof),
+	regexp.AssertNoWordBoundary: fmt.Sprintf("!(%%[1]sIsW(last) && (c == %d || !%%[1]sIsW(c)) || %%[1]sIsW(c) && (last == %d || !%%[1]sIsW(last)))", eof, bot),
+	regexp.AssertNotDigit:       "!(c >= '0' && c <= '9')",
+	regexp.AssertNotSpace:       "!%[1]sIsS(c)",
+	regexp.AssertNotWord:        "!%[1]sIsW(c)",
+	regexp.AssertSpace:          "%[1]sIsS(c)",
+	regexp.AssertWord:           "%[1]sIsW(c)",
+	regexp.AssertWordBoundary:   fmt.Sprintf("%%[1]sIsW(last) && (c == %d || !%%[1]sIsW(c)) || %%[1]sIsW(c) && (last == %d || !%%[1]sIsW(last))", eof, bot),
+}
+
+func quote(s string) string {
+	if strconv.CanBackquote(s) {
+		return "`" + s + "`"
+	}
+	return strconv.Quote(s)
+}
+
+var genHeader = template.Must(template.New("").Parse(`// Code generated by regexpgen. DO NOT EDIT.
+
+package {{.Pkg}}
+
+import (
+	"io"
+	"unicode/utf8"
+)
+
+// {{.T}} is a precompiled regular expression.
+type {{.T}} struct {
+	add      func(*{{.t}}Machine, *{{.t}}List, int, []int, int)
+	anchored bool
+	complete bool
+	groups   int
+	names    []string
+	prefix   string
+	raw      bool
+	size     int
+	src      string
+	start    int
+	start1   int
+	step     func(*{{.t}}Machine, int) (int, bool)
+}
+
+`))
+
+// genRuntime is a copy of the parts of the VM of package regexp the generated
+// matchers need. It must be kept in sync with vm.go of package regexp.
+var genRuntime = template.Must(template.New("").Parse(`
+// String returns the source text used to compile the regular expression.
+func (re *{{.T}}) String() string { return re.src }
+
+// LiteralPrefix returns a literal string that must begin any match of the
+// regular expression re. It returns the boolean true if the literal string
+// comprises the entire regular expression.
+func (re *{{.T}}) LiteralPrefix() (prefix string, complete bool) { return re.prefix, re.complete }
+
+// NumSubexp returns the number of parenthesized subexpressions.
+func (re *{{.T}}) NumSubexp() int { return re.groups - 1 }
+
+// SubexpNames returns the names of the parenthesized subexpressions.
+func (re *{{.T}}) SubexpNames() []string { return re.names }
+
+// Match reports whether b contains any match of re.
+func (re *{{.T}}) Match(b []byte) bool { return re.newBytes(b).find() != nil }
+
+// MatchString reports whether s contains any match of re.
+func (re *{{.T}}) MatchString(s string) bool { return re.newString(s).find() != nil }
+
+// Find returns the text of the leftmost match in b or nil.
+func (re *{{.T}}) Find(b []byte) []byte {
+	if a := re.FindIndex(b); a != nil {
+		return b[a[0]:a[1]]
+	}
+
+	return nil
+}
+
+// FindIndex returns the location of the leftmost match in b or nil.
+func (re *{{.T}}) FindIndex(b []byte) []int {
+	if a := re.newBytes(b).find(); a != nil {
+		return a[:2]
+	}
+
+	return nil
+}
+
+// FindString returns the text of the leftmost match in s or "".
+func (re *{{.T}}) FindString(s string) string {
+	if a := re.FindStringIndex(s); a != nil {
+		return s[a[0]:a[1]]
+	}
+
+	return ""
+}
+
+// FindStringIndex returns the location of the leftmost match in s or nil.
+func (re *{{.T}}) FindStringIndex(s string) []int {
+	if a := re.newString(s).find(); a != nil {
+		return a[:2]
+	}
+
+	return nil
+}
+
+// FindReaderIndex returns the location of the leftmost match in text read
+// from r or nil.
+func (re *{{.T}}) FindReaderIndex(r io.RuneReader) []int {
+	if a := re.newReader(r).find(); a != nil {
+		return a[:2]
+	}
+
+	return nil
+}
+
+// FindSubmatch returns the text of the leftmost match in b and of its
+// subexpressions or nil.
+func (re *{{.T}}) FindSubmatch(b []byte) [][]byte {
+	return {{.t}}Bytes(b, re.FindSubmatchIndex(b))
+}
+
+// FindSubmatchIndex returns the locations of the leftmost match in b and of
+// its subexpressions or nil.
+func (re *{{.T}}) FindSubmatchIndex(b []byte) []int { return re.newBytes(b).find() }
+
+// FindStringSubmatch returns the text of the leftmost match in s and of its
+// subexpressions or nil.
+func (re *{{.T}}) FindStringSubmatch(s string) []string {
+	return {{.t}}Strings(s, re.FindStringSubmatchIndex(s))
+}
+
+// FindStringSubmatchIndex returns the locations of the leftmost match in s
+// and of its subexpressions or nil.
+func (re *{{.T}}) FindStringSubmatchIndex(s string) []int { return re.newString(s).find() }
+
+// FindReaderSubmatchIndex returns the locations of the leftmost match in
+// text read from r and of its subexpressions or nil.
+func (re *{{.T}}) FindReaderSubmatchIndex(r io.RuneReader) []int { return re.newReader(r).find() }
+
+// FindAll returns the text of at most n, all if n < 0, successive matches in
+// b or nil.
+func (re *{{.T}}) FindAll(b []byte, n int) [][]byte {
+	var r [][]byte
+	for _, a := range re.FindAllIndex(b, n) {
+		r = append(r, b[a[0]:a[1]])
+	}
+	return r
+}
+
+// FindAllIndex returns the locations of at most n, all if n < 0, successive
+// matches in b or nil.
+func (re *{{.T}}) FindAllIndex(b []byte, n int) [][]int {
+	r := re.newBytes(b).findAll(n)
+	for i, v := range r {
+		r[i] = v[:2]
+	}
+	return r
+}
+
+// FindAllString returns the text of at most n, all if n < 0, successive
+// matches in s or nil.
+func (re *{{.T}}) FindAllString(s string, n int) []string {
+	var r []string
+	for _, a := range re.FindAllStringIndex(s, n) {
+		r = append(r, s[a[0]:a[1]])
+	}
+	return r
+}
+
+// FindAllStringIndex returns the locations of at most n, all if n < 0,
+// successive matches in s or nil.
+func (re *{{.T}}) FindAllStringIndex(s string, n int) [][]int {
+	r := re.newString(s).findAll(n)
+	for i, v := range r {
+		r[i] = v[:2]
+	}
+	return r
+}
+
+// FindAllSubmatch returns the text of at most n, all if n < 0, successive
+// matches in b and of their subexpressions or nil.
+func (re *{{.T}}) FindAllSubmatch(b []byte, n int) [][][]byte {
+	var r [][][]byte
+	for _, a := range re.FindAllSubmatchIndex(b, n) {
+		r = append(r, {{.t}}Bytes(b, a))
+	}
+	return r
+}
+
+// FindAllSubmatchIndex returns the locations of at most n, all if n < 0,
+// successive matches in b and of their subexpressions or nil.
+func (re *{{.T}}) FindAllSubmatchIndex(b []byte, n int) [][]int { return re.newBytes(b).findAll(n) }
+
+// FindAllStringSubmatch returns the text of at most n, all if n < 0,
+// successive matches in s and of their subexpressions or nil.
+func (re *{{.T}}) FindAllStringSubmatch(s string, n int) [][]string {
+	var r [][]string
+	for _, a := range re.FindAllStringSubmatchIndex(s, n) {
+		r = append(r, {{.t}}Strings(s, a))
+	}
+	return r
+}
+
+// FindAllStringSubmatchIndex returns the locations of at most n, all if n <
+// 0, successive matches in s and of their subexpressions or nil.
+func (re *{{.T}}) FindAllStringSubmatchIndex(s string, n int) [][]int {
+	return re.newString(s).findAll(n)
+}
+
+func {{.t}}Bytes(b []byte, a []int) [][]byte {
+	if a == nil {
+		return nil
+	}
+
+	r := make([][]byte, len(a)/2)
+	for i := range r {
+		if a[2*i] >= 0 {
+			r[i] = b[a[2*i]:a[2*i+1]]
+		}
+	}
+	return r
+}
+
+func {{.t}}Strings(s string, a []int) []string {
+	if a == nil {
+		return nil
+	}
+
+	r := make([]string, len(a)/2)
+	for i := range r {
+		if a[2*i] >= 0 {
+			r[i] = s[a[2*i]:a[2*i+1]]
+		}
+	}
+	return r
+}
+
+type {{.t}}Thread struct {
+	pc  int
+	cap []int
+}
+
+type {{.t}}List struct {
+	dense  []{{.t}}Thread
+	sparse []int
+	n      int
+	match  bool
+}
+
+func (l *{{.t}}List) has(pc int) bool {
+	i := l.sparse[pc]
+	return i < l.n && l.dense[i].pc == pc
+}
+
+func (l *{{.t}}List) include(pc int, cap []int) {
+	l.dense[l.n] = {{.t}}Thread{pc, cap}
+	l.sparse[pc] = l.n
+	l.n++
+}
+
+type {{.t}}Machine struct {
+	re      *{{.T}}
+	r       io.RuneReader
+	br      io.ByteReader
+	bsrc    []byte
+	src     string
+	saved   []int
+	pos     int
+	sz      int
+	last    rune
+	c       rune
+	first   bool
+	closed  bool
+	isBytes bool
+	lists   [2]{{.t}}List
+}
+
+func (re *{{.T}}) newBytes(b []byte) *{{.t}}Machine {
+	return (&{{.t}}Machine{re: re, bsrc: b, isBytes: true}).init()
+}
+
+func (re *{{.T}}) newString(s string) *{{.t}}Machine {
+	return (&{{.t}}Machine{re: re, src: s}).init()
+}
+
+func (re *{{.T}}) newReader(r io.RuneReader) *{{.t}}Machine {
+	m := &{{.t}}Machine{re: re, r: r}
+	if re.raw {
+		var ok bool
+		if m.br, ok = r.(io.ByteReader); !ok {
+			m.br = &{{.t}}RuneBytes{r: r}
+		}
+	}
+	return m.init()
+}
+
+type {{.t}}RuneBytes struct {
+	r   io.RuneReader
+	buf [utf8.UTFMax]byte
+	i   int
+	n   int
+}
+
+func (b *{{.t}}RuneBytes) ReadByte() (byte, error) {
+	if b.i == b.n {
+		r, _, err := b.r.ReadRune()
+		if err != nil {
+			return 0, err
+		}
+
+		b.i = 0
+		b.n = utf8.EncodeRune(b.buf[:], r)
+	}
+	b.i++
+	return b.buf[b.i-1], nil
+}
+
+func (m *{{.t}}Machine) init() *{{.t}}Machine {
+	for i := range m.lists {
+		m.lists[i].dense = make([]{{.t}}Thread, m.re.size)
+		m.lists[i].sparse = make([]int, m.re.size)
+	}
+	m.c, m.sz = m.readRune()
+	m.last = -3
+	m.first = true
+	return m
+}
+
+func (m *{{.t}}Machine) readRune() (r rune, sz int) {
+	if m.closed {
+		return -2, 0
+	}
+
+	switch {
+	case m.br != nil:
+		c, err := m.br.ReadByte()
+		if err != nil {
+			r = -1
+			m.closed = true
+			break
+		}
+
+		r, sz = rune(c), 1
+	case m.r != nil:
+		var err error
+		if r, sz, err = m.r.ReadRune(); err != nil {
+			r = -1
+			sz = 0
+			m.closed = true
+		}
+	case m.isBytes:
+		switch {
+		case m.pos >= len(m.bsrc):
+			r = -1
+			m.closed = true
+		case m.bsrc[m.pos] < utf8.RuneSelf || m.re.raw:
+			r, sz = rune(m.bsrc[m.pos]), 1
+		default:
+			r, sz = utf8.DecodeRune(m.bsrc[m.pos:])
+		}
+	default:
+		switch {
+		case m.pos >= len(m.src):
+			r = -1
+			m.closed = true
+		case m.src[m.pos] < utf8.RuneSelf || m.re.raw:
+			r, sz = rune(m.src[m.pos]), 1
+		default:
+			r, sz = utf8.DecodeRuneInString(m.src[m.pos:])
+		}
+	}
+	return r, sz
+}
+
+func (m *{{.t}}Machine) next() {
+	m.last = m.c
+	m.pos += m.sz
+	m.c, m.sz = m.readRune()
+}
+
+func (m *{{.t}}Machine) update(cap []int, i, pos int) []int {
+	r := make([]int, 2*m.re.groups)
+	for i := range r {
+		r[i] = -1
+	}
+	copy(r, cap)
+	r[i] = pos
+	return r
+}
+
+func (m *{{.t}}Machine) accept(l *{{.t}}List, cap []int) {
+	if m.saved == nil || m.saved[0] == cap[0] {
+		m.saved = cap
+	}
+	l.match = true
+}
+
+func (m *{{.t}}Machine) find() []int {
+	clist, nlist := &m.lists[0], &m.lists[1]
+	clist.n = 0
+	clist.match = false
+	m.saved = nil
+	start := m.re.start1
+	if m.re.anchored {
+		if !m.first {
+			return nil
+		}
+
+		start = m.re.start
+	}
+	m.re.add(m, clist, start, nil, m.pos)
+	for m.first = false; clist.n != 0; clist, nlist = nlist, clist {
+		nlist.n = 0
+		nlist.match = false
+		for i := 0; i < clist.n && !nlist.match; i++ {
+			t := &clist.dense[i]
+			if pc, consume := m.re.step(m, t.pc); pc >= 0 {
+				pos := m.pos
+				if consume {
+					pos += m.sz
+				}
+				m.re.add(m, nlist, pc, t.cap, pos)
+			}
+		}
+		if m.c != -1 && clist.match && !nlist.match {
+			break
+		}
+
+		if m.saved != nil && m.saved[0] == m.saved[1] {
+			m.next()
+			break
+		}
+
+		m.next()
+	}
+	return m.saved
+}
+
+func (m *{{.t}}Machine) findAll(n int) [][]int {
+	var r [][]int
+	var prev []int
+	for m.c != -2 && len(r) != n {
+		a := m.find()
+		if a == nil {
+			return r
+		}
+
+		if prev == nil || a[0] != a[1] || prev[0] == prev[1] {
+			r = append(r, a)
+		}
+		prev = a
+	}
+	return r
+}
+
+func {{.t}}IsS(c rune) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\f' || c == '\r'
+}
+
+func {{.t}}IsW(c rune) bool {
+	return c >= '0' && c <= '9' || c >= 'A' && c <= 'Z' || c >= 'c' && c <= 'z' || c == '_'
+}
+`))
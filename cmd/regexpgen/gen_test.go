@@ -0,0 +1,112 @@
+// Copyright 2017 The Regexp Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cznic/regexp"
+)
+
+func TestGenerate(t *testing.T) {
+	a := []string{
+		``,
+		`^abc`,
+		`a+b*`,
+		`(a|ab)(c|bcd)(d*)`,
+		`(?i)AbC`,
+		`[a-c]+x?`,
+		`[^a\n]+`,
+		`(?P<name>[a-z]+)@([a-z]+)[.]com`,
+		`.*`,
+		`x*$`,
+		`日本語`,
+		`[0-9]+[.][0-9]+`,
+		`\bfoo\b`,
+		`(?m)^a$`,
+		`[\dx]+`,
+		`\d\w+\s`,
+		`(?s).+`,
+	}
+	var names []string
+	var res []*regexp.Regexp
+	for i, v := range a {
+		names = append(names, fmt.Sprintf("re%d", i))
+		res = append(res, regexp.MustCompile(v))
+	}
+	inputs := []string{"", "abc", "xabcx", "a\nb", "foo bar", "日本語 abc123 x@example.com", "aab abcd 3.14 1\n22 foo@bar.com"}
+	var b bytes.Buffer
+	if err := generate(&b, "main", "Matcher", names, res); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := generate(&b, "main", "Matcher", []string{"1x"}, res[:1]); err == nil {
+		t.Fatal("expected error")
+	}
+
+	if testing.Short() {
+		t.Skip("-short")
+	}
+
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	dir, err := ioutil.TempDir("", "regexp-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	var m bytes.Buffer
+	m.WriteString("package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfor i, re := range []*Matcher{")
+	for _, v := range names {
+		fmt.Fprintf(&m, "%s, ", v)
+	}
+	fmt.Fprintf(&m, "} {\n\t\tfor _, s := range %#v {\n", inputs)
+	m.WriteString("\t\t\tfmt.Printf(\"%d %q %v %v %q %v\\n\", i, s, re.MatchString(s), re.Match([]byte(s)), re.FindSubmatch([]byte(s)), re.FindAllStringSubmatchIndex(s, -1))\n\t\t}\n\t}\n}\n")
+	for _, v := range []struct{ name, src string }{
+		{"go.mod", "module test\n"},
+		{"main.go", m.String()},
+		{"regexps.go", b.String()},
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, v.name), []byte(v.src), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmd := exec.Command(goTool, "run", ".")
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%s\n%v", out, err)
+	}
+
+	var e bytes.Buffer
+	for i, re := range res {
+		for _, s := range inputs {
+			fmt.Fprintf(&e, "%d %q %v %v %q %v\n", i, s, re.MatchString(s), re.Match([]byte(s)), re.FindSubmatch([]byte(s)), re.FindAllStringSubmatchIndex(s, -1))
+		}
+	}
+	g := strings.Split(string(out), "\n")
+	for i, v := range strings.Split(e.String(), "\n") {
+		if i >= len(g) {
+			t.Fatalf("missing output: %s", v)
+		}
+
+		if g[i] != v {
+			t.Errorf("`%s`\ngot %s\nexp %s", a[i/len(inputs)], g[i], v)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2017 The Regexp Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command regexpgen writes Go source code of precompiled regular expression
+// matchers. It is intended to be used with go generate, for example
+//
+//	//go:generate regexpgen -pkg foo -o regexps.go ident=[a-z]+ number=[0-9]+
+//
+// declares in package foo the variables ident and number of type *Matcher,
+// having the Match and Find* methods of *regexp.Regexp.
+//
+// Usage
+//
+//	regexpgen [flags] [name=pattern]...
+//
+// Flags
+//
+//	-i file	read additional name pattern pairs from file, one per line
+//		separated by white space; blank lines and lines starting with #
+//		are ignored
+//	-o file	output file, the standard output by default
+//	-pkg name	package name of the generated file (default "main")
+//	-posix	compile the patterns using regexp.CompilePOSIX
+//	-raw	compile the patterns using regexp.CompileRaw
+//	-type name	name of the generated type (default "Matcher")
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/cznic/regexp"
+)
+
+func main() {
+	if err := main1(); err != nil {
+		fmt.Fprintf(os.Stderr, "regexpgen: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func main1() error {
+	oIn := flag.String("i", "", "read name pattern pairs from file")
+	oOut := flag.String("o", "", "output file")
+	oPkg := flag.String("pkg", "main", "package name")
+	oPOSIX := flag.Bool("posix", false, "use CompilePOSIX")
+	oRaw := flag.Bool("raw", false, "use CompileRaw")
+	oType := flag.String("type", "Matcher", "type name")
+	flag.Parse()
+
+	var names, patterns []string
+	for _, v := range flag.Args() {
+		i := strings.IndexByte(v, '=')
+		if i < 0 {
+			return fmt.Errorf("invalid argument, expected name=pattern: %q", v)
+		}
+
+		names = append(names, v[:i])
+		patterns = append(patterns, v[i+1:])
+	}
+	if *oIn != "" {
+		f, err := os.Open(*oIn)
+		if err != nil {
+			return err
+		}
+
+		defer f.Close()
+
+		s := bufio.NewScanner(f)
+		for line := 1; s.Scan(); line++ {
+			t := strings.TrimSpace(s.Text())
+			if t == "" || strings.HasPrefix(t, "#") {
+				continue
+			}
+
+			a := strings.SplitN(t, " ", 2)
+			if len(a) != 2 {
+				a = strings.SplitN(t, "\t", 2)
+			}
+			if len(a) != 2 {
+				return fmt.Errorf("%s:%d: expected name pattern", *oIn, line)
+			}
+
+			names = append(names, a[0])
+			patterns = append(patterns, strings.TrimSpace(a[1]))
+		}
+		if err := s.Err(); err != nil {
+			return err
+		}
+	}
+
+	if len(names) == 0 {
+		return fmt.Errorf("no patterns")
+	}
+
+	var res []*regexp.Regexp
+	for _, v := range patterns {
+		var re *regexp.Regexp
+		var err error
+		switch {
+		case *oRaw:
+			re, err = regexp.CompileRaw(v)
+		case *oPOSIX:
+			re, err = regexp.CompilePOSIX(v)
+		default:
+			re, err = regexp.Compile(v)
+		}
+		if err != nil {
+			return err
+		}
+
+		res = append(res, re)
+	}
+
+	var b bytes.Buffer
+	if err := generate(&b, *oPkg, *oType, names, res); err != nil {
+		return err
+	}
+
+	if *oOut == "" {
+		_, err := os.Stdout.Write(b.Bytes())
+		return err
+	}
+
+	return ioutil.WriteFile(*oOut, b.Bytes(), 0666)
+}
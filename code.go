@@ -0,0 +1,194 @@
+// Copyright 2017 The Regexp Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package regexp
+
+import (
+	"unicode/utf8"
+)
+
+// code is a program compiled to closures. The closures of a state do what
+// vm.addThread and vm.step do for it, without dispatching on its kind.
+type code struct {
+	add  []func(*vm, *threadList, thread, int)
+	step []func(*vm, *thread, *threadList) // Nil for non consuming states.
+}
+
+// Specialize returns a copy of re with its program compiled to closures,
+// which the matching methods then execute instead of interpreting the
+// program. That makes matching faster at the cost of compile time and memory.
+// The result is a new Regexp, so re can be used concurrently meanwhile.
+func (re *Regexp) Specialize() *Regexp {
+	x := re.Copy()
+	if x.code == nil {
+		x.code = newCode(x)
+	}
+	return x
+}
+
+// newCode compiles the program of re to closures. They must be kept in sync
+// with vm.addThread and vm.step.
+func newCode(re *Regexp) *code {
+	c := &code{
+		add:  make([]func(*vm, *threadList, thread, int), len(re.prog)),
+		step: make([]func(*vm, *thread, *threadList), len(re.prog)),
+	}
+	add := c.add
+	for pc := range re.prog {
+		pc := pc
+		op := re.prog[pc]
+		out, out1 := op.out, op.out1
+		switch op.kind {
+		case opAccept:
+			c.add[pc] = func(vm *vm, l *threadList, t thread, pos int) {
+				if l.has(pc) {
+					return
+				}
+
+				l.include(t)
+				if sub := t.saved.sub; vm.saved == nil || vm.saved[0] == sub[0] {
+					vm.saved = sub
+				}
+				l.match = true
+			}
+		case opAssert:
+			f := asserts[op.arg]
+			c.add[pc] = func(vm *vm, l *threadList, t thread, pos int) {
+				if l.has(pc) {
+					return
+				}
+
+				l.include(t)
+				if f(vm.first, vm.last, vm.c) {
+					add[out](vm, l, thread{out, t.saved}, pos)
+				}
+			}
+			switch op.arg {
+			case assertB, assertNotB, assertBOT, assertEOT, assertEOTMulitline:
+				c.step[pc] = func(vm *vm, t *thread, l *threadList) {
+					if f(vm.first, vm.last, vm.c) {
+						add[out](vm, l, thread{out, t.saved}, vm.pos)
+					}
+				}
+			}
+		case opChar:
+			c.add[pc] = consumer(pc)
+			r := rune(op.arg)
+			c.step[pc] = func(vm *vm, t *thread, l *threadList) {
+				if vm.c == r {
+					add[out](vm, l, thread{out, t.saved}, vm.pos+vm.sz)
+				}
+			}
+		case
+			opCharClass,
+			opNotCharClass:
+
+			c.add[pc] = consumer(pc)
+			ranges := re.regs[op.arg:op.arg2]
+			in := func(vm *vm) bool { return vm.set(ranges) }
+			if !hasAsserts(ranges) {
+				var ascii [utf8.RuneSelf]bool
+				for i := 0; i < len(ranges); i += 2 {
+					for c := ranges[i]; c <= ranges[i+1] && c < utf8.RuneSelf; c++ {
+						ascii[c] = true
+					}
+				}
+				in = func(vm *vm) bool {
+					if c := vm.c; c >= 0 && c < utf8.RuneSelf {
+						return ascii[c]
+					}
+
+					return vm.set(ranges)
+				}
+			}
+			switch op.kind {
+			case opCharClass:
+				c.step[pc] = func(vm *vm, t *thread, l *threadList) {
+					if in(vm) {
+						add[out](vm, l, thread{out, t.saved}, vm.pos+vm.sz)
+					}
+				}
+			default:
+				c.step[pc] = func(vm *vm, t *thread, l *threadList) {
+					if !in(vm) && vm.c != eof {
+						add[out](vm, l, thread{out, t.saved}, vm.pos+vm.sz)
+					}
+				}
+			}
+		case opDot:
+			c.add[pc] = consumer(pc)
+			c.step[pc] = func(vm *vm, t *thread, l *threadList) {
+				if vm.c != '\n' && vm.c != eof {
+					add[out](vm, l, thread{out, t.saved}, vm.pos+vm.sz)
+				}
+			}
+		case opDotNL:
+			c.add[pc] = consumer(pc)
+			c.step[pc] = func(vm *vm, t *thread, l *threadList) {
+				if pc == vm.scan && l.len == 0 && vm.saved == nil {
+					// See vm.step.
+					vm.idle = true
+					return
+				}
+
+				if vm.c != eof {
+					add[out](vm, l, thread{out, t.saved}, vm.pos+vm.sz)
+				}
+			}
+		case opNop:
+			c.add[pc] = func(vm *vm, l *threadList, t thread, pos int) {
+				if l.has(pc) {
+					return
+				}
+
+				l.include(t)
+				add[out](vm, l, thread{out, t.saved}, pos)
+			}
+			c.step[pc] = func(vm *vm, t *thread, l *threadList) {
+				add[out](vm, l, thread{out, t.saved}, vm.pos)
+			}
+		case opSave:
+			n := op.arg
+			c.add[pc] = func(vm *vm, l *threadList, t thread, pos int) {
+				if l.has(pc) {
+					return
+				}
+
+				l.include(t)
+				add[out](vm, l, thread{out, t.saved.update(vm, n, pos)}, pos)
+			}
+		case opSplit:
+			c.add[pc] = func(vm *vm, l *threadList, t thread, pos int) {
+				if l.has(pc) {
+					return
+				}
+
+				l.include(t)
+				add[out](vm, l, thread{out, t.saved}, pos)
+				add[out1](vm, l, thread{out1, t.saved}, pos)
+			}
+		default:
+			panic("internal error")
+		}
+	}
+	return c
+}
+
+// consumer returns the add closure of a rune consuming state pc.
+func consumer(pc int) func(*vm, *threadList, thread, int) {
+	return func(vm *vm, l *threadList, t thread, pos int) {
+		if !l.has(pc) {
+			l.include(t)
+		}
+	}
+}
+
+func hasAsserts(ranges []int) bool {
+	for i := 0; i < len(ranges); i += 2 {
+		if ranges[i] < 0 {
+			return true
+		}
+	}
+	return false
+}
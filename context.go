@@ -0,0 +1,192 @@
+// Copyright 2017 The Regexp Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package regexp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The Context variants of the search functions stop when their context is
+// done or when they run out of the step budget set by WithBudget. They report
+// that by a *LimitError. The FindAll variants return also the matches found
+// before the search stopped.
+
+const limitCheck = 256 // Steps between checks of the context.
+
+// ErrBudget is the error of a search that ran out of its step budget.
+var ErrBudget = errors.New("step budget exceeded")
+
+// LimitError is the error of a search stopped before it completed.
+type LimitError struct {
+	Err error // ErrBudget or the error of the context.
+	Pos int   // Input offset where the search stopped.
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("regexp: search stopped at offset %d: %v", e.Pos, e.Err)
+}
+
+// Unwrap returns e.Err.
+func (e *LimitError) Unwrap() error { return e.Err }
+
+// WithBudget returns a copy of re whose Context search methods stop after n
+// steps. A step examines one rune, or one byte for CompileRaw, of the input.
+// The search for the next match can examine again the rune after the previous
+// one. Input skipped by a literal scan is not counted. The budget thus bounds
+// the work of the search and the input read from a RuneReader. A budget n <= 0
+// means no limit.
+func (re *Regexp) WithBudget(n int64) *Regexp {
+	x := re.Copy()
+	x.budget = n
+	return x
+}
+
+// limit is the budget of a search.
+type limit struct {
+	ctx   context.Context
+	done  <-chan struct{}
+	err   error // Set when the search is stopped.
+	n     int   // Steps done.
+	steps int64 // Steps left, negative if not limited.
+}
+
+func newLimit(ctx context.Context, budget int64) *limit {
+	l := &limit{ctx: ctx, done: ctx.Done(), steps: -1}
+	if budget > 0 {
+		l.steps = budget
+	}
+	return l
+}
+
+// step reports whether vm can do another step. If not, it stops vm.
+func (l *limit) step(vm *vm) bool {
+	if l.steps >= 0 {
+		if l.steps == 0 {
+			return l.stop(vm, ErrBudget)
+		}
+
+		l.steps--
+	}
+	if l.n%limitCheck == 0 && l.done != nil {
+		select {
+		case <-l.done:
+			return l.stop(vm, l.ctx.Err())
+		default:
+		}
+	}
+	l.n++
+	return true
+}
+
+func (l *limit) stop(vm *vm, err error) bool {
+	l.err = &LimitError{Err: err, Pos: vm.pos}
+	vm.closed = true
+	vm.c = pastEOF
+	return false
+}
+
+func limited(ctx context.Context, vm *vm) *vm {
+	vm.limit = newLimit(ctx, vm.re.budget)
+	return vm
+}
+
+// MatchContext is like Match but stops when ctx is done or the step budget
+// of re runs out.
+func (re *Regexp) MatchContext(ctx context.Context, b []byte) (bool, error) {
+	vm := limited(ctx, newBytesVM(re, b))
+	r := vm.match()
+	return r, vm.limit.err
+}
+
+// MatchStringContext is like MatchString but stops when ctx is done or the
+// step budget of re runs out.
+func (re *Regexp) MatchStringContext(ctx context.Context, s string) (bool, error) {
+	vm := limited(ctx, newStringVM(re, s))
+	r := vm.match()
+	return r, vm.limit.err
+}
+
+// MatchReaderContext is like MatchReader but stops when ctx is done or the
+// step budget of re runs out.
+func (re *Regexp) MatchReaderContext(ctx context.Context, r io.RuneReader) (bool, error) {
+	vm := limited(ctx, newVM(re, r))
+	m := vm.match()
+	return m, vm.limit.err
+}
+
+// FindIndexContext is like FindIndex but stops when ctx is done or the step
+// budget of re runs out.
+func (re *Regexp) FindIndexContext(ctx context.Context, b []byte) ([]int, error) {
+	return re.findContext(limited(ctx, newBytesVM(re, b)), 2)
+}
+
+// FindStringIndexContext is like FindStringIndex but stops when ctx is done or
+// the step budget of re runs out.
+func (re *Regexp) FindStringIndexContext(ctx context.Context, s string) ([]int, error) {
+	return re.findContext(limited(ctx, newStringVM(re, s)), 2)
+}
+
+// FindReaderIndexContext is like FindReaderIndex but stops when ctx is done or
+// the step budget of re runs out.
+func (re *Regexp) FindReaderIndexContext(ctx context.Context, r io.RuneReader) ([]int, error) {
+	return re.findContext(limited(ctx, newVM(re, r)), 2)
+}
+
+// FindSubmatchIndexContext is like FindSubmatchIndex but stops when ctx is
+// done or the step budget of re runs out.
+func (re *Regexp) FindSubmatchIndexContext(ctx context.Context, b []byte) ([]int, error) {
+	return re.findContext(limited(ctx, newBytesVM(re, b)), 2*re.groups)
+}
+
+// FindStringSubmatchIndexContext is like FindStringSubmatchIndex but stops
+// when ctx is done or the step budget of re runs out.
+func (re *Regexp) FindStringSubmatchIndexContext(ctx context.Context, s string) ([]int, error) {
+	return re.findContext(limited(ctx, newStringVM(re, s)), 2*re.groups)
+}
+
+// FindReaderSubmatchIndexContext is like FindReaderSubmatchIndex but stops
+// when ctx is done or the step budget of re runs out.
+func (re *Regexp) FindReaderSubmatchIndexContext(ctx context.Context, r io.RuneReader) ([]int, error) {
+	return re.findContext(limited(ctx, newVM(re, r)), 2*re.groups)
+}
+
+func (re *Regexp) findContext(vm *vm, n int) ([]int, error) {
+	a := vm.find()
+	if a != nil {
+		a = a[:n]
+	}
+	return a, vm.limit.err
+}
+
+// FindAllIndexContext is like FindAllIndex but stops when ctx is done or the
+// step budget of re runs out.
+func (re *Regexp) FindAllIndexContext(ctx context.Context, b []byte, n int) ([][]int, error) {
+	vm := limited(ctx, newBytesVM(re, b))
+	return re.findAllIndex(vm, n), vm.limit.err
+}
+
+// FindAllStringIndexContext is like FindAllStringIndex but stops when ctx is
+// done or the step budget of re runs out.
+func (re *Regexp) FindAllStringIndexContext(ctx context.Context, s string, n int) ([][]int, error) {
+	vm := limited(ctx, newStringVM(re, s))
+	return re.findAllIndex(vm, n), vm.limit.err
+}
+
+// FindAllSubmatchIndexContext is like FindAllSubmatchIndex but stops when ctx
+// is done or the step budget of re runs out.
+func (re *Regexp) FindAllSubmatchIndexContext(ctx context.Context, b []byte, n int) ([][]int, error) {
+	vm := limited(ctx, newBytesVM(re, b))
+	return re.findAllSubmatchIndex(vm, n), vm.limit.err
+}
+
+// FindAllStringSubmatchIndexContext is like FindAllStringSubmatchIndex but
+// stops when ctx is done or the step budget of re runs out.
+func (re *Regexp) FindAllStringSubmatchIndexContext(ctx context.Context, s string, n int) ([][]int, error) {
+	vm := limited(ctx, newStringVM(re, s))
+	return re.findAllSubmatchIndex(vm, n), vm.limit.err
+}
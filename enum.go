@@ -9,6 +9,7 @@ type opcode int
 const (
 	opAccept opcode = iota
 	opAssert
+	opByteRange
 	opChar
 	opCharClass
 	opDot
@@ -271,7 +271,10 @@ func tryCompile(s string) (re *Regexp, err error) {
 			err = fmt.Errorf("panic: %v", r)
 		}
 	}()
-	return Compile(s)
+	if re, err = Compile(s); err == nil && *oSpecialize {
+		re = re.Specialize()
+	}
+	return re, err
 }
 
 func parseResult(t *testing.T, file string, lineno int, res string) []int {
@@ -534,7 +537,7 @@ Reading:
 				}
 			}
 
-			re, err := compile(pattern, syn, true)
+			re, err := compile(pattern, syn, true, false)
 			if err != nil {
 				if shouldCompile {
 					t.Errorf("%s:%d: %#q did not compile", file, lineno, pattern)
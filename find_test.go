@@ -147,7 +147,7 @@ func build(n int, x ...int) [][]int {
 
 func TestFind(t *testing.T) {
 	for _, test := range findTests {
-		re := MustCompile(test.pat)
+		re := mustCompile(test.pat)
 		if re.String() != test.pat {
 			t.Errorf("String() = `%s`; should be `%s`", re.String(), test.pat)
 		}
@@ -170,7 +170,7 @@ func TestFind(t *testing.T) {
 
 func TestFindString(t *testing.T) {
 	for _, test := range findTests {
-		result := MustCompile(test.pat).FindString(test.text)
+		result := mustCompile(test.pat).FindString(test.text)
 		switch {
 		case len(test.matches) == 0 && len(result) == 0:
 			// ok
@@ -208,19 +208,19 @@ func testFindIndex(test *FindTest, result []int, t *testing.T) {
 
 func TestFindIndex(t *testing.T) {
 	for _, test := range findTests {
-		testFindIndex(&test, MustCompile(test.pat).FindIndex([]byte(test.text)), t)
+		testFindIndex(&test, mustCompile(test.pat).FindIndex([]byte(test.text)), t)
 	}
 }
 
 func TestFindStringIndex(t *testing.T) {
 	for _, test := range findTests {
-		testFindIndex(&test, MustCompile(test.pat).FindStringIndex(test.text), t)
+		testFindIndex(&test, mustCompile(test.pat).FindStringIndex(test.text), t)
 	}
 }
 
 func TestFindReaderIndex(t *testing.T) {
 	for _, test := range findTests {
-		testFindIndex(&test, MustCompile(test.pat).FindReaderIndex(strings.NewReader(test.text)), t)
+		testFindIndex(&test, mustCompile(test.pat).FindReaderIndex(strings.NewReader(test.text)), t)
 	}
 }
 
@@ -228,7 +228,7 @@ func TestFindReaderIndex(t *testing.T) {
 
 func TestFindAll(t *testing.T) {
 	for _, test := range findTests {
-		result := MustCompile(test.pat).FindAll([]byte(test.text), -1)
+		result := mustCompile(test.pat).FindAll([]byte(test.text), -1)
 		switch {
 		case test.matches == nil && result == nil:
 			// ok
@@ -253,7 +253,7 @@ func TestFindAll(t *testing.T) {
 
 func TestFindAllString(t *testing.T) {
 	for _, test := range findTests {
-		result := MustCompile(test.pat).FindAllString(test.text, -1)
+		result := mustCompile(test.pat).FindAllString(test.text, -1)
 		switch {
 		case test.matches == nil && result == nil:
 			// ok
@@ -299,13 +299,13 @@ func testFindAllIndex(test *FindTest, result [][]int, t *testing.T) {
 
 func TestFindAllIndex(t *testing.T) {
 	for _, test := range findTests {
-		testFindAllIndex(&test, MustCompile(test.pat).FindAllIndex([]byte(test.text), -1), t)
+		testFindAllIndex(&test, mustCompile(test.pat).FindAllIndex([]byte(test.text), -1), t)
 	}
 }
 
 func TestFindAllStringIndex(t *testing.T) {
 	for _, test := range findTests {
-		testFindAllIndex(&test, MustCompile(test.pat).FindAllStringIndex(test.text, -1), t)
+		testFindAllIndex(&test, mustCompile(test.pat).FindAllStringIndex(test.text, -1), t)
 	}
 }
 
@@ -333,7 +333,7 @@ func testSubmatchBytes(test *FindTest, n int, submatches []int, result [][]byte,
 
 func TestFindSubmatch(t *testing.T) {
 	for _, test := range findTests {
-		result := MustCompile(test.pat).FindSubmatch([]byte(test.text))
+		result := mustCompile(test.pat).FindSubmatch([]byte(test.text))
 		switch {
 		case test.matches == nil && result == nil:
 			// ok
@@ -369,7 +369,7 @@ func testSubmatchString(test *FindTest, n int, submatches []int, result []string
 
 func TestFindStringSubmatch(t *testing.T) {
 	for _, test := range findTests {
-		result := MustCompile(test.pat).FindStringSubmatch(test.text)
+		result := mustCompile(test.pat).FindStringSubmatch(test.text)
 		switch {
 		case test.matches == nil && result == nil:
 			// ok
@@ -410,19 +410,19 @@ func testFindSubmatchIndex(test *FindTest, result []int, t *testing.T) {
 
 func TestFindSubmatchIndex(t *testing.T) {
 	for _, test := range findTests {
-		testFindSubmatchIndex(&test, MustCompile(test.pat).FindSubmatchIndex([]byte(test.text)), t)
+		testFindSubmatchIndex(&test, mustCompile(test.pat).FindSubmatchIndex([]byte(test.text)), t)
 	}
 }
 
 func TestFindStringSubmatchIndex(t *testing.T) {
 	for _, test := range findTests {
-		testFindSubmatchIndex(&test, MustCompile(test.pat).FindStringSubmatchIndex(test.text), t)
+		testFindSubmatchIndex(&test, mustCompile(test.pat).FindStringSubmatchIndex(test.text), t)
 	}
 }
 
 func TestFindReaderSubmatchIndex(t *testing.T) {
 	for _, test := range findTests {
-		testFindSubmatchIndex(&test, MustCompile(test.pat).FindReaderSubmatchIndex(strings.NewReader(test.text)), t)
+		testFindSubmatchIndex(&test, mustCompile(test.pat).FindReaderSubmatchIndex(strings.NewReader(test.text)), t)
 	}
 }
 
@@ -430,7 +430,7 @@ func TestFindReaderSubmatchIndex(t *testing.T) {
 
 func TestFindAllSubmatch(t *testing.T) {
 	for _, test := range findTests {
-		result := MustCompile(test.pat).FindAllSubmatch([]byte(test.text), -1)
+		result := mustCompile(test.pat).FindAllSubmatch([]byte(test.text), -1)
 		switch {
 		case test.matches == nil && result == nil:
 			// ok
@@ -450,7 +450,7 @@ func TestFindAllSubmatch(t *testing.T) {
 
 func TestFindAllStringSubmatch(t *testing.T) {
 	for _, test := range findTests {
-		result := MustCompile(test.pat).FindAllStringSubmatch(test.text, -1)
+		result := mustCompile(test.pat).FindAllStringSubmatch(test.text, -1)
 		switch {
 		case test.matches == nil && result == nil:
 			// ok
@@ -487,12 +487,12 @@ func testFindAllSubmatchIndex(test *FindTest, result [][]int, t *testing.T) {
 
 func TestFindAllSubmatchIndex(t *testing.T) {
 	for _, test := range findTests {
-		testFindAllSubmatchIndex(&test, MustCompile(test.pat).FindAllSubmatchIndex([]byte(test.text), -1), t)
+		testFindAllSubmatchIndex(&test, mustCompile(test.pat).FindAllSubmatchIndex([]byte(test.text), -1), t)
 	}
 }
 
 func TestFindAllStringSubmatchIndex(t *testing.T) {
 	for _, test := range findTests {
-		testFindAllSubmatchIndex(&test, MustCompile(test.pat).FindAllStringSubmatchIndex(test.text, -1), t)
+		testFindAllSubmatchIndex(&test, mustCompile(test.pat).FindAllStringSubmatchIndex(test.text, -1), t)
 	}
 }
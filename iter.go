@@ -0,0 +1,56 @@
+// Copyright 2017 The Regexp Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.23
+
+package regexp
+
+import (
+	"io"
+	"iter"
+)
+
+// All returns an iterator over the locations of the successive matches in b,
+// as defined by the 'All' description in the package comment. A location is
+// a pair of indices like those returned by FindIndex. The matches are found
+// one at a time while iterating.
+func (re *Regexp) All(b []byte) iter.Seq[[]int] {
+	return func(yield func([]int) bool) {
+		allMatches(newBytesVM(re, b), -1, func(a []int) bool { return yield(a[:2]) })
+	}
+}
+
+// AllString is like All but searches s.
+func (re *Regexp) AllString(s string) iter.Seq[[]int] {
+	return func(yield func([]int) bool) {
+		allMatches(newStringVM(re, s), -1, func(a []int) bool { return yield(a[:2]) })
+	}
+}
+
+// AllReader is like All but searches the text read by the RuneReader, with
+// locations being byte offsets in that text. The text is read as the
+// iteration proceeds, so the returned iterator can be used only once.
+func (re *Regexp) AllReader(r io.RuneReader) iter.Seq[[]int] {
+	return func(yield func([]int) bool) {
+		allMatches(newVM(re, r), -1, func(a []int) bool { return yield(a[:2]) })
+	}
+}
+
+// AllSubmatch returns an iterator over the locations of the successive
+// matches in b and of their subexpressions, like those returned by
+// FindSubmatchIndex.
+func (re *Regexp) AllSubmatch(b []byte) iter.Seq[[]int] {
+	return func(yield func([]int) bool) { allMatches(newBytesVM(re, b), -1, yield) }
+}
+
+// AllStringSubmatch is like AllSubmatch but searches s.
+func (re *Regexp) AllStringSubmatch(s string) iter.Seq[[]int] {
+	return func(yield func([]int) bool) { allMatches(newStringVM(re, s), -1, yield) }
+}
+
+// AllReaderSubmatch is like AllSubmatch but searches the text read by the
+// RuneReader. See AllReader.
+func (re *Regexp) AllReaderSubmatch(r io.RuneReader) iter.Seq[[]int] {
+	return func(yield func([]int) bool) { allMatches(newVM(re, r), -1, yield) }
+}
@@ -0,0 +1,69 @@
+// Copyright 2017 The Regexp Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.23
+
+package regexp
+
+import (
+	"iter"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func collect(seq iter.Seq[[]int]) (r [][]int) {
+	for v := range seq {
+		r = append(r, v)
+	}
+	return r
+}
+
+func TestAll(t *testing.T) {
+	for i, test := range findTests {
+		re := MustCompile(test.pat)
+		e := re.FindAllStringIndex(test.text, -1)
+		if g := collect(re.AllString(test.text)); !reflect.DeepEqual(g, e) {
+			t.Errorf("%d: %s: AllString got %v exp %v", i, &test, g, e)
+		}
+		if g := collect(re.All([]byte(test.text))); !reflect.DeepEqual(g, e) {
+			t.Errorf("%d: %s: All got %v exp %v", i, &test, g, e)
+		}
+		if g := collect(re.AllReader(strings.NewReader(test.text))); !reflect.DeepEqual(g, e) {
+			t.Errorf("%d: %s: AllReader got %v exp %v", i, &test, g, e)
+		}
+		e = re.FindAllStringSubmatchIndex(test.text, -1)
+		if g := collect(re.AllStringSubmatch(test.text)); !reflect.DeepEqual(g, e) {
+			t.Errorf("%d: %s: AllStringSubmatch got %v exp %v", i, &test, g, e)
+		}
+		if g := collect(re.AllSubmatch([]byte(test.text))); !reflect.DeepEqual(g, e) {
+			t.Errorf("%d: %s: AllSubmatch got %v exp %v", i, &test, g, e)
+		}
+		if g := collect(re.AllReaderSubmatch(strings.NewReader(test.text))); !reflect.DeepEqual(g, e) {
+			t.Errorf("%d: %s: AllReaderSubmatch got %v exp %v", i, &test, g, e)
+		}
+	}
+}
+
+func TestAllBreak(t *testing.T) {
+	re := MustCompile(`a*`)
+	s := strings.Repeat("baaab", 100)
+	r := strings.NewReader(s)
+	n := 0
+	for v := range re.AllReader(r) {
+		if n++; n == 3 {
+			if g, e := v, []int{5, 5}; !reflect.DeepEqual(g, e) {
+				t.Errorf("got %v exp %v", g, e)
+			}
+			break
+		}
+	}
+	if n != 3 {
+		t.Fatal(n)
+	}
+
+	if r.Len() == 0 {
+		t.Error("input consumed after break")
+	}
+}
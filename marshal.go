@@ -0,0 +1,580 @@
+// Copyright 2017 The Regexp Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package regexp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"regexp/syntax"
+	"strings"
+	"unicode"
+)
+
+const (
+	binaryMagic   = "RGX"
+	binaryVersion = 2
+)
+
+const (
+	binaryComplete = 1 << iota
+	binaryLongest
+	binaryRaw
+	binaryPOSIX
+	binaryAnchored
+	binaryPrefilter // The prefilter follows the program.
+	binaryReverse   // The reverse program follows the prefilter, if any.
+	binaryBits      // The bit parallel program follows the reverse program, if any.
+	binaryBitsEmpty
+	binaryBitsEmpty0
+
+	binaryFlags = binaryBitsEmpty0<<1 - 1
+)
+
+// MarshalBinary implements encoding.BinaryMarshaler. The result is a
+// versioned encoding of the compiled program of re and of the results of its
+// analysis, which UnmarshalBinary restores without parsing or analyzing the
+// regular expression again.
+func (re *Regexp) MarshalBinary() ([]byte, error) {
+	re.longestMu.Lock()
+	longest := re.longest
+	re.longestMu.Unlock()
+	var flags uint64
+	if re.complete {
+		flags |= binaryComplete
+	}
+	if longest {
+		flags |= binaryLongest
+	}
+	if re.raw {
+		flags |= binaryRaw
+	}
+	if re.posix {
+		flags |= binaryPOSIX
+	}
+	if re.anchored {
+		flags |= binaryAnchored
+	}
+	if re.prefilter != nil {
+		flags |= binaryPrefilter
+	}
+	if re.reverse != nil {
+		flags |= binaryReverse
+	}
+	if p := re.bits; p != nil {
+		flags |= binaryBits
+		if p.empty {
+			flags |= binaryBitsEmpty
+		}
+		if p.empty0 {
+			flags |= binaryBitsEmpty0
+		}
+	}
+	b := append([]byte(binaryMagic), binaryVersion)
+	b = appendUvarint(b, flags)
+	b = appendBinaryString(b, re.src)
+	b = appendBinaryString(b, re.prefix)
+	b = appendUvarint(b, uint64(re.groups))
+	b = appendUvarint(b, uint64(re.accept))
+	b = appendUvarint(b, uint64(re.start))
+	b = appendUvarint(b, uint64(re.start1))
+	b = appendUvarint(b, uint64(len(re.groupNames)))
+	for _, v := range re.groupNames {
+		b = appendBinaryString(b, v)
+	}
+	b = appendUvarint(b, uint64(len(re.regs)))
+	for _, v := range re.regs {
+		b = appendVarint(b, int64(v))
+	}
+	b = appendProg(b, re.prog)
+	if p := re.prefilter; p != nil {
+		b = appendBinaryStrings(b, p.lits)
+		b = appendBinaryStrings(b, p.inner)
+		b = appendUvarint(b, uint64(p.before))
+		b = appendVarint(b, int64(p.window))
+	}
+	if p := re.reverse; p != nil {
+		b = appendVarint(b, int64(p.start))
+		b = appendProg(b, p.prog)
+	}
+	if p := re.bits; p != nil {
+		b = appendUvarint(b, p.first)
+		b = appendUvarint(b, p.first0)
+		b = appendUvarint(b, p.final)
+		b = appendUvarint(b, uint64(len(p.ranges)))
+		for i, v := range p.ranges {
+			b = appendUvarint(b, p.follow[i])
+			b = appendUvarint(b, uint64(len(v)))
+			for _, r := range v {
+				b = appendUvarint(b, uint64(r[0]))
+				b = appendUvarint(b, uint64(r[1]))
+			}
+		}
+	}
+	return b, nil
+}
+
+func appendProg(b []byte, prog []instr) []byte {
+	b = appendUvarint(b, uint64(len(prog)))
+	for _, v := range prog {
+		b = appendUvarint(b, uint64(v.kind))
+		b = appendVarint(b, int64(v.arg))
+		b = appendVarint(b, int64(v.arg2))
+		b = appendVarint(b, int64(v.out))
+		b = appendVarint(b, int64(v.out1))
+	}
+	return b
+}
+
+func appendBinaryStrings(b []byte, a []string) []byte {
+	b = appendUvarint(b, uint64(len(a)))
+	for _, v := range a {
+		b = appendBinaryString(b, v)
+	}
+	return b
+}
+
+func appendBinaryString(b []byte, s string) []byte {
+	return append(appendUvarint(b, uint64(len(s))), s...)
+}
+
+func appendUvarint(b []byte, n uint64) []byte {
+	var a [binary.MaxVarintLen64]byte
+	return append(b, a[:binary.PutUvarint(a[:], n)]...)
+}
+
+func appendVarint(b []byte, n int64) []byte {
+	var a [binary.MaxVarintLen64]byte
+	return append(b, a[:binary.PutVarint(a[:], n)]...)
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler. It sets re to the
+// regular expression encoded in data by MarshalBinary after checking the
+// program is valid.
+func (re *Regexp) UnmarshalBinary(data []byte) error {
+	if len(data) < len(binaryMagic)+1 || string(data[:len(binaryMagic)]) != binaryMagic {
+		return fmt.Errorf("regexp: UnmarshalBinary: invalid data")
+	}
+
+	if v := data[len(binaryMagic)]; v != binaryVersion {
+		return fmt.Errorf("regexp: UnmarshalBinary: unsupported version %v", v)
+	}
+
+	d := &binaryDecoder{b: data[len(binaryMagic)+1:]}
+	flags := d.uvarint()
+	if flags&^binaryFlags != 0 {
+		return fmt.Errorf("regexp: UnmarshalBinary: unsupported flags %#x", flags)
+	}
+
+	x := newRegexp(d.str())
+	x.complete = flags&binaryComplete != 0
+	x.longest = flags&binaryLongest != 0
+	x.raw = flags&binaryRaw != 0
+	x.posix = flags&binaryPOSIX != 0
+	x.prefix = d.str()
+	x.groups = d.int()
+	x.accept = d.int()
+	x.start = d.int()
+	x.start1 = d.int()
+	x.groupNames = make([]string, d.len())
+	for i := range x.groupNames {
+		x.groupNames[i] = d.str()
+	}
+	x.regs = make([]int, d.len())
+	for i := range x.regs {
+		x.regs[i] = int(d.varint())
+	}
+	x.prog = d.prog()
+	x.anchored = flags&binaryAnchored != 0
+	if flags&binaryPrefilter != 0 {
+		lits := d.strs()
+		inner := d.strs()
+		before := d.int()
+		window := int(d.varint())
+		if d.err == nil {
+			if err := checkPrefilter(lits, inner, window); err != nil {
+				return fmt.Errorf("regexp: UnmarshalBinary: %v", err)
+			}
+
+			x.prefilter = newPrefilter(lits)
+			x.prefilter.inner = inner
+			x.prefilter.before = before
+			x.prefilter.window = window
+		}
+	}
+	if flags&binaryReverse != 0 {
+		x.reverse = &reverseProg{start: int(d.varint())}
+		x.reverse.prog = d.prog()
+	}
+	if flags&binaryBits != 0 {
+		x.bits = &bitProg{empty: flags&binaryBitsEmpty != 0, empty0: flags&binaryBitsEmpty0 != 0}
+		x.bits.first = d.uvarint()
+		x.bits.first0 = d.uvarint()
+		x.bits.final = d.uvarint()
+		n := d.len()
+		follow := make([]uint64, 0, n)
+		for ; n != 0; n-- {
+			follow = append(follow, d.uvarint())
+			var r [][2]rune
+			for n := d.len(); n != 0; n-- {
+				r = append(r, [2]rune{d.rune(), d.rune()})
+			}
+			x.bits.ranges = append(x.bits.ranges, r)
+		}
+		if d.err == nil {
+			if err := x.bits.check(follow); err != nil {
+				return fmt.Errorf("regexp: UnmarshalBinary: %v", err)
+			}
+
+			x.bits.tables(follow)
+		}
+	}
+	switch {
+	case d.err != nil:
+		return fmt.Errorf("regexp: UnmarshalBinary: %v", d.err)
+	case len(d.b) != 0:
+		return fmt.Errorf("regexp: UnmarshalBinary: %v bytes of trailing data", len(d.b))
+	}
+
+	if err := x.validate(); err != nil {
+		return fmt.Errorf("regexp: UnmarshalBinary: %v", err)
+	}
+
+	*re = *x.getSubexps()
+	return nil
+}
+
+type binaryDecoder struct {
+	b   []byte
+	err error
+}
+
+func (d *binaryDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+
+	n, i := binary.Uvarint(d.b)
+	if i <= 0 {
+		d.err = fmt.Errorf("invalid varint")
+		return 0
+	}
+
+	d.b = d.b[i:]
+	return n
+}
+
+func (d *binaryDecoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+
+	n, i := binary.Varint(d.b)
+	if i <= 0 {
+		d.err = fmt.Errorf("invalid varint")
+		return 0
+	}
+
+	d.b = d.b[i:]
+	return n
+}
+
+func (d *binaryDecoder) int() int {
+	n := d.uvarint()
+	if n > maxProg*maxRepCount {
+		d.err = fmt.Errorf("value out of range: %v", n)
+		return 0
+	}
+
+	return int(n)
+}
+
+// len decodes the length of a sequence. Every item takes at least a byte.
+func (d *binaryDecoder) len() int {
+	n := d.uvarint()
+	if n > uint64(len(d.b)) {
+		d.err = fmt.Errorf("invalid length %v", n)
+		return 0
+	}
+
+	return int(n)
+}
+
+func (d *binaryDecoder) str() string {
+	n := d.len()
+	s := string(d.b[:n])
+	d.b = d.b[n:]
+	return s
+}
+
+func (d *binaryDecoder) strs() (a []string) {
+	for n := d.len(); n != 0; n-- {
+		a = append(a, d.str())
+	}
+	return a
+}
+
+func (d *binaryDecoder) rune() rune {
+	n := d.uvarint()
+	if n > unicode.MaxRune {
+		d.err = fmt.Errorf("invalid rune %#x", n)
+		return 0
+	}
+
+	return rune(n)
+}
+
+func (d *binaryDecoder) prog() []instr {
+	prog := make([]instr, d.len())
+	for i := range prog {
+		prog[i] = instr{
+			kind: opcode(d.uvarint()),
+			arg:  int(d.varint()),
+			arg2: int(d.varint()),
+			out:  int(d.varint()),
+			out1: int(d.varint()),
+		}
+	}
+	return prog
+}
+
+// validate checks that the fields of re form a program the matching engines
+// can execute.
+func (re *Regexp) validate() error {
+	n := len(re.prog)
+	if re.groups < 1 || len(re.groupNames) != re.groups {
+		return fmt.Errorf("invalid number of groups")
+	}
+
+	if len(re.regs)%2 != 0 {
+		return fmt.Errorf("invalid character class ranges")
+	}
+
+	for i := 0; i < len(re.regs); i += 2 {
+		lo, hi := re.regs[i], re.regs[i+1]
+		switch {
+		case lo < 0:
+			if asserts[-lo] == nil {
+				return fmt.Errorf("invalid character class assertion %v", -lo)
+			}
+		case hi < lo || hi > unicode.MaxRune:
+			return fmt.Errorf("invalid character class range %#x-%#x", lo, hi)
+		}
+	}
+	for s := range re.prog {
+		if err := re.validateState(s, &re.prog[s], n, 0); err != nil {
+			return err
+		}
+	}
+	for _, v := range []int{re.accept, re.start, re.start1} {
+		if v < 0 || v >= n {
+			return fmt.Errorf("invalid start or accept state %v", v)
+		}
+	}
+	if re.prog[re.accept].kind != opAccept {
+		return fmt.Errorf("invalid accept state %v", re.accept)
+	}
+
+	for _, v := range []int{re.start, re.start1} {
+		if err := re.checkInvariants(v); err != nil {
+			return err
+		}
+	}
+
+	if p := re.reverse; p != nil {
+		// Transitions to -1 end a path of the reverse program.
+		n := len(p.prog)
+		for s := range p.prog {
+			if err := re.validateState(s, &p.prog[s], n, -1); err != nil {
+				return fmt.Errorf("reverse program: %v", err)
+			}
+		}
+		if p.start < -1 || p.start >= n {
+			return fmt.Errorf("reverse program: invalid start state %v", p.start)
+		}
+	}
+	return nil
+}
+
+// validateState checks state s of a program having n states. Transitions to
+// states before lo are invalid.
+func (re *Regexp) validateState(s int, p *instr, n, lo int) error {
+	switch p.kind {
+	case opAccept:
+		return nil
+	case opAssert:
+		if asserts[p.arg] == nil {
+			return fmt.Errorf("state %v: invalid assertion %v", s, p.arg)
+		}
+	case opChar:
+		if p.arg < 0 || p.arg > unicode.MaxRune || re.raw && p.arg > 0xff {
+			return fmt.Errorf("state %v: invalid character %#x", s, p.arg)
+		}
+	case
+		opCharClass,
+		opNotCharClass:
+
+		if p.arg < 0 || p.arg > p.arg2 || p.arg2 > len(re.regs) || (p.arg2-p.arg)%2 != 0 {
+			return fmt.Errorf("state %v: invalid character class", s)
+		}
+	case
+		opDot,
+		opDotNL,
+		opNop:
+
+		// ok
+	case opSave:
+		if p.arg < 0 || p.arg >= 2*re.groups {
+			return fmt.Errorf("state %v: invalid group %v", s, p.arg)
+		}
+	case opSplit:
+		if p.out1 < lo || p.out1 >= n {
+			return fmt.Errorf("state %v: invalid transition %v", s, p.out1)
+		}
+	default:
+		return fmt.Errorf("state %v: invalid opcode %v", s, p.kind)
+	}
+	if p.out < lo || p.out >= n {
+		return fmt.Errorf("state %v: invalid transition %v", s, p.out)
+	}
+	return nil
+}
+
+// checkPrefilter checks the fields of a prefilter. Multiple literals are
+// searched for by an automaton, which does not support empty literals.
+func checkPrefilter(lits, inner []string, window int) error {
+	for _, v := range lits {
+		if v == "" {
+			return fmt.Errorf("invalid prefilter literal")
+		}
+	}
+	if window < -1 || window >= len(inner) {
+		return fmt.Errorf("invalid prefilter window %v", window)
+	}
+
+	return nil
+}
+
+// check checks the fields of b set by UnmarshalBinary.
+func (b *bitProg) check(follow []uint64) error {
+	if len(follow) > maxBitPositions {
+		return fmt.Errorf("too many bit positions %v", len(follow))
+	}
+
+	all := uint64(1)<<uint(len(follow)) - 1
+	for _, v := range append([]uint64{b.first, b.first0, b.final}, follow...) {
+		if v&^all != 0 {
+			return fmt.Errorf("invalid bit positions %#x", v)
+		}
+	}
+	for _, r := range b.ranges {
+		for i, v := range r {
+			if v[0] > v[1] || i != 0 && v[0] <= r[i-1][1] {
+				return fmt.Errorf("invalid bit position range %#x-%#x", v[0], v[1])
+			}
+		}
+	}
+	return nil
+}
+
+// checkInvariants checks the states reachable from s, which must be valid,
+// are states the matching engines execute.
+func (re *Regexp) checkInvariants(s int) error {
+	for _, state := range re.reachable(s, -1) {
+		ps := &re.prog[state]
+		switch ps.kind {
+		case
+			opAccept,
+			opAssert,
+			opChar,
+			opCharClass,
+			opDot,
+			opDotNL,
+			opNotCharClass,
+			opSave,
+			opSplit:
+			// nop
+		case opNop:
+			if noOpt {
+				break
+			}
+
+			return fmt.Errorf("state %v: unexpected %v", state, ps.kind)
+		default:
+			return fmt.Errorf("state %v: unexpected %v", state, ps.kind)
+		}
+	}
+	return nil
+}
+
+// Modes of a regular expression in its text form.
+var textModes = []struct {
+	prefix string
+	set    func(*Regexp)
+	is     func(*Regexp) bool
+}{
+	{"(?POSIX)", func(re *Regexp) { re.posix = true }, func(re *Regexp) bool { return re.posix }},
+	{"(?raw)", func(re *Regexp) { re.raw = true }, func(re *Regexp) bool { return re.raw }},
+	{"(?longest)", func(re *Regexp) { re.longest = true }, func(re *Regexp) bool { return re.longest }},
+}
+
+// MarshalText implements encoding.TextMarshaler. The result is the source
+// text of re, preceded by the mode prefixes described in UnmarshalText. A
+// regexp compiled by Compile and not made longest has no prefix, its text is
+// the same as the one of a regexp of the standard library. Other text cannot
+// be read by the standard library, which rejects the prefixes.
+func (re *Regexp) MarshalText() ([]byte, error) {
+	re.longestMu.Lock()
+	x := *re
+	re.longestMu.Unlock()
+	var b []byte
+	for _, v := range textModes {
+		if v.is(&x) {
+			b = append(b, v.prefix...)
+		}
+	}
+	return append(b, re.src...), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler. It sets re to the
+// regular expression compiled from text produced by MarshalText. A parse
+// error is reported as an *Error with Offset relative to the start of text.
+//
+// The text is a regular expression optionally preceded, in this order, by
+//
+//	(?POSIX)   compile the rest like CompilePOSIX
+//	(?raw)     compile the rest like CompileRaw
+//	(?longest) call Longest on the result
+//
+// These prefixes are not regular expression syntax. They are accepted only
+// at the start of text, so text not starting with them, like the text of a
+// regexp of the standard library, is compiled like Compile does.
+func (re *Regexp) UnmarshalText(text []byte) error {
+	s := string(text)
+	var mode Regexp
+	off := 0
+	for _, v := range textModes {
+		if strings.HasPrefix(s[off:], v.prefix) {
+			v.set(&mode)
+			off += len(v.prefix)
+		}
+	}
+	syntaxMode := syntax.Perl
+	if mode.posix {
+		syntaxMode = syntax.POSIX
+	}
+	x, err := compile(s[off:], syntaxMode, mode.longest, mode.raw)
+	if err != nil {
+		if e, ok := err.(*Error); ok {
+			e.Offset += off
+		}
+		return err
+	}
+
+	*re = *x
+	return nil
+}
+
+// Set implements flag.Value. It is like UnmarshalText.
+func (re *Regexp) Set(s string) error { return re.UnmarshalText([]byte(s)) }
@@ -0,0 +1,160 @@
+// Copyright 2017 The Regexp Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package regexp
+
+import (
+	"io"
+	"unicode/utf8"
+)
+
+// MatchResult is a match of a regular expression and of its subexpressions.
+// Group 0 is the whole match, groups 1 and up are the parenthesized
+// subexpressions numbered in the order of their opening parentheses. A group
+// that did not participate in the match has no text and no location.
+type MatchResult struct {
+	bsrc []byte
+	loc  []int
+	re   *Regexp
+	src  string
+}
+
+// FindMatch returns the leftmost match in b of the regular expression, or
+// nil if there is no match.
+func (re *Regexp) FindMatch(b []byte) *MatchResult {
+	if loc := re.FindSubmatchIndex(b); loc != nil {
+		return &MatchResult{bsrc: b, loc: loc, re: re}
+	}
+
+	return nil
+}
+
+// FindStringMatch returns the leftmost match in s of the regular expression,
+// or nil if there is no match.
+func (re *Regexp) FindStringMatch(s string) *MatchResult {
+	if loc := re.FindStringSubmatchIndex(s); loc != nil {
+		return &MatchResult{loc: loc, re: re, src: s}
+	}
+
+	return nil
+}
+
+// FindReaderMatch returns the leftmost match of the regular expression in
+// text read from the RuneReader, or nil if there is no match. Locations are
+// byte offsets in the text read. The result keeps a copy of the text read,
+// which is the UTF-8 encoding of the runes returned by r. Input r reports as
+// invalid UTF-8 is copied as bytes 0xff, one per byte consumed.
+func (re *Regexp) FindReaderMatch(r io.RuneReader) *MatchResult {
+	rr := &recordingReader{r: r}
+	if loc := newVM(re, rr).find(); loc != nil {
+		return &MatchResult{bsrc: rr.buf, loc: loc, re: re}
+	}
+
+	return nil
+}
+
+// recordingReader keeps a copy of the input read from r.
+type recordingReader struct {
+	br  io.ByteReader
+	buf []byte
+	r   io.RuneReader
+}
+
+func (r *recordingReader) ReadRune() (rune, int, error) {
+	c, n, err := r.r.ReadRune()
+	if err != nil {
+		return c, n, err
+	}
+
+	switch {
+	case n == utf8.RuneLen(c):
+		r.buf = append(r.buf, string(c)...)
+	default:
+		for i := 0; i < n; i++ {
+			r.buf = append(r.buf, 0xff)
+		}
+	}
+	return c, n, nil
+}
+
+// ReadByte is used by raw regexps only.
+func (r *recordingReader) ReadByte() (byte, error) {
+	if r.br == nil {
+		var ok bool
+		if r.br, ok = r.r.(io.ByteReader); !ok {
+			r.br = &runeBytes{r: r.r}
+		}
+	}
+	b, err := r.br.ReadByte()
+	if err == nil {
+		r.buf = append(r.buf, b)
+	}
+	return b, err
+}
+
+// Start returns the byte offset where the match starts.
+func (m *MatchResult) Start() int { return m.loc[0] }
+
+// End returns the byte offset where the match ends.
+func (m *MatchResult) End() int { return m.loc[1] }
+
+// Text returns the text of the match.
+func (m *MatchResult) Text() string { return m.Group(0) }
+
+// NumGroups returns the number of groups, including group 0.
+func (m *MatchResult) NumGroups() int { return len(m.loc) / 2 }
+
+// Participated reports whether group i participated in the match.
+func (m *MatchResult) Participated(i int) bool {
+	return i >= 0 && 2*i < len(m.loc) && m.loc[2*i] >= 0
+}
+
+// Group returns the text of group i. The result is "" if the group did not
+// participate in the match or if there is no such group.
+func (m *MatchResult) Group(i int) string {
+	if !m.Participated(i) {
+		return ""
+	}
+
+	if m.bsrc != nil {
+		return string(m.bsrc[m.loc[2*i]:m.loc[2*i+1]])
+	}
+
+	return m.src[m.loc[2*i]:m.loc[2*i+1]]
+}
+
+// GroupSpan returns the byte offsets where group i starts and ends, or -1, -1
+// if the group did not participate in the match or if there is no such
+// group.
+func (m *MatchResult) GroupSpan(i int) (start, end int) {
+	if !m.Participated(i) {
+		return -1, -1
+	}
+
+	return m.loc[2*i], m.loc[2*i+1]
+}
+
+// Named returns the text of the leftmost group with the given name that
+// participated in the match, or "" if there is no such group.
+func (m *MatchResult) Named(name string) string { return m.Group(m.named(name)) }
+
+// Span returns the byte offsets where the leftmost group with the given name
+// that participated in the match starts and ends, or -1, -1 if there is no
+// such group.
+func (m *MatchResult) Span(name string) (start, end int) { return m.GroupSpan(m.named(name)) }
+
+func (m *MatchResult) named(name string) int {
+	for _, i := range m.re.subexps[name] {
+		if m.Participated(i) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Expand returns template with variables replaced by the corresponding
+// groups of the match, as described for Regexp.Expand.
+func (m *MatchResult) Expand(template string) string {
+	return string(m.re.expand(nil, template, m.bsrc, m.src, m.loc))
+}
@@ -5,7 +5,10 @@
 package regexp
 
 import (
+	"sort"
+	"strings"
 	"sync"
+	"unicode/utf8"
 )
 
 type instr struct {
@@ -22,21 +25,32 @@ func (s *instr) patch(t int) { s.out = t }
 // safe for concurrent use by multiple goroutines.
 type Regexp struct {
 	accept     int
-	complete   bool // Prefix is the whole re.
+	anchored   bool          // Any match must start at the beginning of text.
+	bits       *bitProg      // Non-nil if the bit parallel engine can be used.
+	budget     int64         // See WithBudget.
+	code       *code         // Non-nil if specialized.
+	bprog      *lazyByteProg // See byteProg.
+	complete   bool          // Prefix is the whole re.
 	groupNames []string
 	groups     int
 	longest    bool // See .Longest()
 	longestMu  *sync.Mutex
-	prefix     string // Any match must start with this literal.
+	posix      bool       // See CompilePOSIX.
+	prefilter  *prefilter // Non-nil if any match must start with a literal from a small set.
+	prefix     string     // Any match must start with this literal.
 	prog       []instr
+	raw        bool // See CompileRaw.
 	regs       []int
+	reverse    *reverseProg // Non-nil if any match ends at the end of text.
 	src        string
-	start      int // Full match.
-	start1     int // Partial match.
+	start      int              // Full match.
+	start1     int              // Partial match.
+	subexps    map[string][]int // Indices of the named subexpressions.
 }
 
 func newRegexp(src string) *Regexp {
 	return &Regexp{
+		bprog:      &lazyByteProg{},
 		longestMu:  &sync.Mutex{},
 		groupNames: []string{""},
 		src:        src,
@@ -61,8 +75,31 @@ func (re *Regexp) route(s int) int {
 	return s
 }
 
+// appendLit appends to b the input encoding of c.
+func (re *Regexp) appendLit(b []byte, c rune) []byte {
+	if re.raw {
+		return append(b, byte(c))
+	}
+
+	return append(b, string(c)...)
+}
+
+func (re *Regexp) getSubexps() *Regexp {
+	for i, v := range re.groupNames {
+		if v == "" {
+			continue
+		}
+
+		if re.subexps == nil {
+			re.subexps = map[string][]int{}
+		}
+		re.subexps[v] = append(re.subexps[v], i)
+	}
+	return re
+}
+
 func (re *Regexp) getPrefix() *Regexp {
-	var r []rune
+	var r []byte
 loop:
 	for ip := re.start; ; {
 		op := re.prog[ip]
@@ -72,7 +109,7 @@ loop:
 			re.complete = true
 			return re
 		case opChar:
-			r = append(r, rune(op.arg))
+			r = re.appendLit(r, rune(op.arg))
 		case
 			opNop,
 			opSave:
@@ -95,6 +132,408 @@ loop:
 	return re
 }
 
+// literalPrefixes returns a set of non-empty literals such that any match
+// must start with one of them, or nil if there is no such set with at most
+// maxPrefixes members. No member of the result is a prefix of another one.
+func (re *Regexp) literalPrefixes() []string {
+	var r []string
+	var lit []byte
+	path := make([]bool, len(re.prog))
+	ok := true
+	emit := func() {
+		if len(lit) == 0 || len(r) == maxPrefixes {
+			ok = false
+			return
+		}
+
+		r = append(r, string(lit))
+	}
+	var f func(int)
+	f = func(s int) {
+		if !ok {
+			return
+		}
+
+		if path[s] || len(lit) >= maxPrefixLen {
+			// Truncating a literal is always safe.
+			emit()
+			return
+		}
+
+		path[s] = true
+		switch p := &re.prog[s]; p.kind {
+		case opChar:
+			c := rune(p.arg)
+			if c == utf8.RuneError && !re.raw {
+				// Matches also invalid UTF-8 input.
+				emit()
+				break
+			}
+
+			n := len(lit)
+			lit = re.appendLit(lit, c)
+			f(p.out)
+			lit = lit[:n]
+		case
+			opNop,
+			opSave:
+
+			f(p.out)
+		case opSplit:
+			f(p.out)
+			f(p.out1)
+		case
+			opAccept,
+			opAssert,
+			opCharClass,
+			opDot,
+			opDotNL,
+			opNotCharClass:
+
+			emit()
+		default:
+			panic("internal error")
+		}
+		path[s] = false
+	}
+	f(re.start)
+	if !ok {
+		return nil
+	}
+
+	sort.Strings(r)
+	w := 0
+	for _, v := range r {
+		if w != 0 && strings.HasPrefix(v, r[w-1]) {
+			continue
+		}
+
+		r[w] = v
+		w++
+	}
+	return r[:w]
+}
+
+// edges returns the successors of state s, -1 if not present.
+func (re *Regexp) edges(s int) (out, out1 int) {
+	switch p := &re.prog[s]; p.kind {
+	case opAccept:
+		return -1, -1
+	case
+		opAssert,
+		opChar,
+		opCharClass,
+		opDot,
+		opDotNL,
+		opNotCharClass,
+		opNop,
+		opSave:
+
+		return p.out, -1
+	case opSplit:
+		return p.out, p.out1
+	default:
+		panic("internal error")
+	}
+}
+
+// dominators returns the immediate dominators of the states reachable from
+// re.start and their predecessors. The immediate dominator of an unreachable
+// state is -1.
+func (re *Regexp) dominators() (idom []int, preds [][]int) {
+	// K. D. Cooper, T. J. Harvey, K. Kennedy: A Simple, Fast Dominance
+	// Algorithm.
+	n := len(re.prog)
+	order := make([]int, n) // Postorder number.
+	for i := range order {
+		order[i] = -1
+	}
+	preds = make([][]int, n)
+	var post []int
+	var f func(int)
+	f = func(s int) {
+		order[s] = n // Visiting.
+		out, out1 := re.edges(s)
+		for _, t := range []int{out, out1} {
+			if t < 0 {
+				continue
+			}
+
+			preds[t] = append(preds[t], s)
+			if order[t] < 0 {
+				f(t)
+			}
+		}
+		order[s] = len(post)
+		post = append(post, s)
+	}
+	f(re.start)
+
+	idom = make([]int, n)
+	for i := range idom {
+		idom[i] = -1
+	}
+	idom[re.start] = re.start
+	intersect := func(a, b int) int {
+		for a != b {
+			for order[a] < order[b] {
+				a = idom[a]
+			}
+			for order[b] < order[a] {
+				b = idom[b]
+			}
+		}
+		return a
+	}
+	for changed := true; changed; {
+		changed = false
+		for i := len(post) - 1; i >= 0; i-- {
+			s := post[i]
+			if s == re.start {
+				continue
+			}
+
+			d := -1
+			for _, p := range preds[s] {
+				switch {
+				case idom[p] < 0:
+					// nop
+				case d < 0:
+					d = p
+				default:
+					d = intersect(p, d)
+				}
+			}
+			if d != idom[s] {
+				idom[s] = d
+				changed = true
+			}
+		}
+	}
+	return idom, preds
+}
+
+// distance returns the maximum number of runes consumed by a path from
+// re.start to state to, or -1 if that number is not bounded.
+func (re *Regexp) distance(to int, preds [][]int) int {
+	// States that can reach to.
+	live := make([]bool, len(re.prog))
+	queue := []int{to}
+	live[to] = true
+	for len(queue) != 0 {
+		s := queue[0]
+		queue = queue[1:]
+		for _, p := range preds[s] {
+			if !live[p] {
+				live[p] = true
+				queue = append(queue, p)
+			}
+		}
+	}
+
+	const visiting = -2
+	memo := make([]int, len(re.prog))
+	for i := range memo {
+		memo[i] = -1
+	}
+	bounded := true
+	var f func(int) int
+	f = func(s int) int {
+		switch m := memo[s]; {
+		case m == visiting:
+			bounded = false
+			return 0
+		case m >= 0:
+			return m
+		}
+
+		if s == to {
+			memo[s] = 0
+			return 0
+		}
+
+		memo[s] = visiting
+		r := 0
+		out, out1 := re.edges(s)
+		for _, t := range []int{out, out1} {
+			if t >= 0 && live[t] {
+				if d := f(t); d > r {
+					r = d
+				}
+			}
+		}
+		switch re.prog[s].kind {
+		case opChar, opCharClass, opDot, opDotNL, opNotCharClass:
+			r++
+		}
+		memo[s] = r
+		return r
+	}
+	if r := f(re.start); bounded {
+		return r
+	}
+
+	return -1
+}
+
+// innerLiterals returns up to maxInner literals any match must contain,
+// longest first, and for each of them the maximum number of runes a match
+// can consume before the literal or -1 if that is not bounded.
+func (re *Regexp) innerLiterals() (lits []string, before []int) {
+	idom, preds := re.dominators()
+	if idom[re.accept] < 0 {
+		return nil, nil
+	}
+
+	type lit struct {
+		s     string
+		state int
+	}
+	var a []lit
+	for s := re.accept; ; s = idom[s] {
+		if p := &re.prog[s]; p.kind == opChar {
+			var b []byte
+		loop:
+			for t := s; len(b) < maxInnerLen; {
+				switch p := &re.prog[t]; p.kind {
+				case opChar:
+					c := rune(p.arg)
+					if c == utf8.RuneError && !re.raw {
+						break loop
+					}
+
+					b = re.appendLit(b, c)
+					t = p.out
+				case
+					opAssert,
+					opNop,
+					opSave:
+
+					t = p.out
+				default:
+					break loop
+				}
+			}
+			if len(b) != 0 {
+				a = append(a, lit{string(b), s})
+			}
+		}
+		if s == re.start {
+			break
+		}
+	}
+	sort.SliceStable(a, func(i, j int) bool { return len(a[i].s) > len(a[j].s) })
+	for _, v := range a {
+		if len(lits) == maxInner {
+			break
+		}
+
+		dup := false
+		for _, w := range lits {
+			if strings.Contains(w, v.s) {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			lits = append(lits, v.s)
+			before = append(before, re.distance(v.state, preds))
+		}
+	}
+	return lits, before
+}
+
+// startAsserts reports whether an assertion is reachable from re.start
+// without consuming input.
+func (re *Regexp) startAsserts() bool {
+	seen := make([]bool, len(re.prog))
+	var f func(int) bool
+	f = func(s int) bool {
+		if seen[s] {
+			return false
+		}
+
+		seen[s] = true
+		switch p := &re.prog[s]; p.kind {
+		case opAssert:
+			return true
+		case
+			opNop,
+			opSave:
+
+			return f(p.out)
+		case opSplit:
+			return f(p.out) || f(p.out1)
+		}
+		return false
+	}
+	return f(re.start)
+}
+
+// getAnchor sets re.anchored if every path from re.start passes \A before
+// consuming input.
+func (re *Regexp) getAnchor() *Regexp {
+	if noOpt {
+		return re
+	}
+
+	seen := make([]bool, len(re.prog))
+	var f func(int) bool
+	f = func(s int) bool {
+		if seen[s] {
+			return true
+		}
+
+		seen[s] = true
+		switch p := &re.prog[s]; p.kind {
+		case opAssert:
+			return p.arg == assertBOT || f(p.out)
+		case
+			opNop,
+			opSave:
+
+			return f(p.out)
+		case opSplit:
+			return f(p.out) && f(p.out1)
+		}
+		return false
+	}
+	re.anchored = f(re.start)
+	return re
+}
+
+func (re *Regexp) getPrefilter() *Regexp {
+	if noOpt || re.anchored {
+		return re
+	}
+
+	lits := re.literalPrefixes()
+	if len(lits) == 1 && len(re.prefix) > len(lits[0]) && strings.HasPrefix(re.prefix, lits[0]) &&
+		(re.raw || !strings.ContainsRune(re.prefix, utf8.RuneError)) {
+		lits[0] = re.prefix
+	}
+	var inner []string
+	var before []int
+	if len(lits) == 0 && !re.startAsserts() {
+		inner, before = re.innerLiterals()
+	}
+	if len(lits) == 0 && len(inner) == 0 {
+		return re
+	}
+
+	p := newPrefilter(lits)
+	p.window = -1
+	for i := range inner {
+		if b := before[i]; b >= 0 && (p.window < 0 || b*utf8.UTFMax < p.before) {
+			p.window = i
+			p.before = b * utf8.UTFMax
+		}
+	}
+	p.inner = inner
+	re.prefilter = p
+	return re
+}
+
 func (re *Regexp) optimize() *Regexp {
 	if noOpt {
 		return re
@@ -163,8 +602,8 @@ func (re *Regexp) reachable(in, out int) []int {
 		}
 	}
 	f(in)
-	r := make([]int, len(set.dense))
-	for i := range set.dense {
+	r := make([]int, set.len)
+	for i := range r {
 		r[i] = set.dense[i].pc
 	}
 	return r
@@ -4,9 +4,9 @@ package regexp
 
 import "fmt"
 
-const _opcode_name = "opAcceptopAssertopCharopCharClassopDotopDotNLopNotCharClassopNopopSaveopSplit"
+const _opcode_name = "opAcceptopAssertopByteRangeopCharopCharClassopDotopDotNLopNotCharClassopNopopSaveopSplit"
 
-var _opcode_index = [...]uint8{0, 8, 16, 22, 33, 38, 45, 59, 64, 70, 77}
+var _opcode_index = [...]uint8{0, 8, 16, 27, 33, 44, 49, 56, 70, 75, 81, 88}
 
 func (i opcode) String() string {
 	if i < 0 || i >= opcode(len(_opcode_index)-1) {
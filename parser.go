@@ -70,7 +70,7 @@ func (p *parser) patch(s, t int) { p.re.prog[s].patch(t) }
 func (p *parser) parse() (_ *Regexp, err error) {
 	defer func() {
 		if e := recover(); e != nil {
-			err = fmt.Errorf("error parsing regexp: %v", e)
+			err = &Error{Msg: fmt.Sprint(e), Offset: p.pos}
 		}
 		p.re = nil
 	}()
@@ -86,13 +86,13 @@ func (p *parser) parse() (_ *Regexp, err error) {
 	default:
 		panic(fmt.Sprintf("unexpected %c: `%s`", p.c, p.src))
 	}
-	find := p.re.addState(instr{kind: opDot})
+	find := p.re.addState(instr{kind: opDotNL})
 	p.re.start1 = p.re.addState(instr{kind: opSplit, out: p.re.start, out1: find})
 	p.patch(find, p.re.start1)
 	re := p.re
 	re.groups++
 	p.re = nil
-	return re.optimize().getPrefix(), nil
+	return re.optimize().getSubexps().getPrefix().getAnchor().getPrefilter().getReverse().getBitProg(), nil
 }
 
 func (p *parser) expr(capturingGroup bool) (in, out int) {
@@ -214,10 +214,10 @@ func (p *parser) factor(capturingGroup bool) (in, out int) {
 		switch r := p.esc(); {
 		case r < 0:
 			in = p.re.addState(instr{kind: opAssert, arg: int(-r)})
+			out = in
 		default:
-			in = p.re.addState(instr{kind: opChar, arg: int(r)})
+			in, out = p.char(r)
 		}
-		out = in
 	case '*':
 		panic("missing argument to repetition operator: `*`")
 	case '+':
@@ -227,8 +227,7 @@ func (p *parser) factor(capturingGroup bool) (in, out int) {
 	case '{':
 		p.todo()
 	default:
-		in = p.re.addState(instr{kind: opChar, arg: int(p.c)})
-		out = in
+		in, out = p.char(p.c)
 		p.n()
 	}
 
@@ -310,6 +309,26 @@ func (p *parser) factor(capturingGroup bool) (in, out int) {
 	}
 }
 
+// char adds states matching r.
+func (p *parser) char(r rune) (in, out int) {
+	if !p.re.raw || r <= 0xff {
+		in = p.re.addState(instr{kind: opChar, arg: int(r)})
+		return in, in
+	}
+
+	// In raw mode runes not fitting a byte match their UTF-8 encoding.
+	for i, b := range []byte(string(r)) {
+		s := p.re.addState(instr{kind: opChar, arg: int(b)})
+		if i != 0 {
+			p.patch(out, s)
+		} else {
+			in = s
+		}
+		out = s
+	}
+	return in, out
+}
+
 func (p *parser) pushFlags(newFlags syntax.Flags) {
 	p.flagStack = append(p.flagStack, p.flags)
 	p.flags = newFlags
@@ -502,6 +521,13 @@ func (p *parser) set() (in, out int) {
 		case ']':
 			if !first {
 				p.n()
+				if p.re.raw {
+					for i := lo; i < len(p.re.regs); i++ {
+						if p.re.regs[i] > 0xff {
+							panic(fmt.Sprintf("invalid raw character class: `%s`", p.src[pos0:p.pos]))
+						}
+					}
+				}
 				in = p.re.addState(instr{kind: kind, arg: lo, arg2: len(p.re.regs)})
 				return in, in
 			}
@@ -628,11 +654,50 @@ func (p *parser) esc() rune {
 }
 
 func (p *parser) escX() rune {
-	switch p.c {
-	case eof:
-		panic("invalid escape sequence: `\\x`")
-	default:
-		p.todo()
-		panic("TODO261")
+	pos0 := p.pos - len(`\x`)
+	bad := func() {
+		panic(fmt.Sprintf("invalid escape sequence: `%s`", p.src[pos0:p.pos+p.sz]))
+	}
+	if p.c != '{' {
+		hi, lo := unhex(p.c), -1
+		if hi >= 0 {
+			lo = unhex(p.n())
+		}
+		if lo < 0 {
+			bad()
+		}
+
+		p.n()
+		return rune(hi<<4 | lo)
+	}
+
+	var r rune
+	for n := 0; ; n++ {
+		c := p.n()
+		if c == '}' && n != 0 {
+			p.n()
+			return r
+		}
+
+		d := unhex(c)
+		if d < 0 {
+			bad()
+		}
+
+		if r = r<<4 | rune(d); r > unicode.MaxRune {
+			bad()
+		}
+	}
+}
+
+func unhex(c rune) int {
+	switch {
+	case c >= '0' && c <= '9':
+		return int(c - '0')
+	case c >= 'a' && c <= 'f':
+		return int(c - 'a' + 10)
+	case c >= 'A' && c <= 'F':
+		return int(c - 'A' + 10)
 	}
+	return -1
 }
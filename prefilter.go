@@ -0,0 +1,181 @@
+// Copyright 2017 The Regexp Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package regexp
+
+import (
+	"bytes"
+	"strings"
+)
+
+const (
+	maxInner     = 4  // Required inner literals searched for.
+	maxInnerLen  = 64 // Longer required inner literals are truncated.
+	maxPrefixLen = 8  // Longer literal prefixes are truncated.
+	maxPrefixes  = 64 // Prevent a huge automaton for (a|b)(c|d)(e|f)...
+)
+
+// prefilter finds the positions where a match can start by searching for
+// literals.
+//
+// If lits is not empty, any match begins with one of them. A single literal is
+// searched for using strings.Index, a set of literals using an Aho-Corasick
+// automaton.
+//
+// Any match contains all of inner. If window >= 0, any match starts at most
+// before bytes ahead of an occurrence of inner[window].
+type prefilter struct {
+	inner  []string
+	lits   []string
+	before int
+	maxLen int // Of lits.
+	window int
+
+	// Aho-Corasick automaton, used only if len(lits) > 1.
+	class  [256]int // Byte equivalence classes, 0 for bytes not in any literal.
+	first  [256]bool
+	nclass int
+	next   []int // next[nclass*state+class] is the successor state.
+	out    []int // out[state] is the length of the longest literal ending in state.
+}
+
+func newPrefilter(lits []string) *prefilter {
+	p := &prefilter{lits: lits}
+	for _, v := range lits {
+		if len(v) > p.maxLen {
+			p.maxLen = len(v)
+		}
+	}
+	if len(lits) == 1 {
+		return p
+	}
+
+	p.nclass = 1
+	for _, v := range lits {
+		p.first[v[0]] = true
+		for i := 0; i < len(v); i++ {
+			if c := v[i]; p.class[c] == 0 {
+				p.class[c] = p.nclass
+				p.nclass++
+			}
+		}
+	}
+
+	// Trie.
+	p.addState()
+	for _, v := range lits {
+		s := 0
+		for i := 0; i < len(v); i++ {
+			j := s*p.nclass + p.class[v[i]]
+			if p.next[j] < 0 {
+				p.next[j] = p.addState()
+			}
+			s = p.next[j]
+		}
+		p.out[s] = len(v)
+	}
+
+	// Failure links, resolved into the transition table in BFS order.
+	fail := make([]int, len(p.out))
+	var queue []int
+	for c := 0; c < p.nclass; c++ {
+		switch s := p.next[c]; {
+		case s < 0:
+			p.next[c] = 0
+		default:
+			queue = append(queue, s)
+		}
+	}
+	for len(queue) != 0 {
+		s := queue[0]
+		queue = queue[1:]
+		if n := p.out[fail[s]]; n > p.out[s] {
+			p.out[s] = n
+		}
+		for c := 0; c < p.nclass; c++ {
+			j := s*p.nclass + c
+			switch t := p.next[j]; {
+			case t < 0:
+				p.next[j] = p.next[fail[s]*p.nclass+c]
+			default:
+				fail[t] = p.next[fail[s]*p.nclass+c]
+				queue = append(queue, t)
+			}
+		}
+	}
+	return p
+}
+
+func (p *prefilter) addState() int {
+	for i := 0; i < p.nclass; i++ {
+		p.next = append(p.next, -1)
+	}
+	p.out = append(p.out, 0)
+	return len(p.out) - 1
+}
+
+// index returns the position of the leftmost occurrence of any of p.lits in s
+// or -1 if there is none.
+func (p *prefilter) index(s string) int {
+	if len(p.lits) == 1 {
+		return strings.Index(s, p.lits[0])
+	}
+
+	r := -1
+	for i, st := 0, 0; i < len(s); i++ {
+		if st == 0 {
+			if r >= 0 {
+				break
+			}
+
+			for i < len(s) && !p.first[s[i]] {
+				i++
+			}
+			if i == len(s) {
+				break
+			}
+		}
+
+		st = p.next[st*p.nclass+p.class[s[i]]]
+		if n := p.out[st]; n != 0 && (r < 0 || i+1-n < r) {
+			r = i + 1 - n
+		}
+		if r >= 0 && i+2-p.maxLen >= r {
+			break
+		}
+	}
+	return r
+}
+
+// indexBytes is like index but for a []byte.
+func (p *prefilter) indexBytes(b []byte) int {
+	if len(p.lits) == 1 {
+		return bytes.Index(b, []byte(p.lits[0]))
+	}
+
+	r := -1
+	for i, st := 0, 0; i < len(b); i++ {
+		if st == 0 {
+			if r >= 0 {
+				break
+			}
+
+			for i < len(b) && !p.first[b[i]] {
+				i++
+			}
+			if i == len(b) {
+				break
+			}
+		}
+
+		st = p.next[st*p.nclass+p.class[b[i]]]
+		if n := p.out[st]; n != 0 && (r < 0 || i+1-n < r) {
+			r = i + 1 - n
+		}
+		if r >= 0 && i+2-p.maxLen >= r {
+			break
+		}
+	}
+	return r
+}
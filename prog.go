@@ -0,0 +1,124 @@
+// Copyright 2017 The Regexp Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package regexp
+
+// Prog is the program of a Regexp, as used by code generators like
+// cmd/regexpgen. The program is matched by a Thompson NFA. A thread at an
+// instruction consuming a rune continues at Out if the rune matches. The
+// other instructions are followed immediately.
+//
+// The representation of programs, including the set of instructions and
+// assertions, is not stable and may change between versions of the package.
+// Code generated from a Prog must be regenerated when the package changes.
+type Prog struct {
+	Inst     []Inst
+	Anchored bool // Any match must start at the beginning of text.
+	Raw      bool // See CompileRaw.
+	Start    int  // Full match.
+	Start1   int  // Partial match.
+}
+
+// InstOp is the kind of an Inst.
+type InstOp int
+
+// Values of InstOp.
+const (
+	InstAccept   InstOp = iota // Match found.
+	InstAssert                 // Continue at Out if assertion Arg holds.
+	InstAny                    // Any rune except newline.
+	InstAnyNL                  // Any rune.
+	InstClass                  // Any rune in Ranges.
+	InstNop                    // Continue at Out.
+	InstNotClass               // Any rune not in Ranges.
+	InstRune                   // Rune Arg.
+	InstSave                   // Record the position as submatch index Arg.
+	InstSplit                  // Continue at both Out and Out1.
+)
+
+// Inst is an instruction of a Prog.
+type Inst struct {
+	Op     InstOp
+	Arg    int    // See InstOp.
+	Out    int    // Next instruction.
+	Out1   int    // Alternative next instruction of InstSplit.
+	Ranges []rune // Pairs of lo, hi. A negative lo is the Assertion -lo.
+}
+
+// Assertion is a condition on the text around the current position or on the
+// current rune.
+type Assertion int
+
+// Values of Assertion.
+const (
+	AssertWordBoundary   Assertion = assertB            // \b
+	AssertBeginText      Assertion = assertBOT          // \A
+	AssertDigit          Assertion = assertD            // \d
+	AssertEndText        Assertion = assertEOT          // \z
+	AssertEndLine        Assertion = assertEOTMulitline // (?m:$)
+	AssertNoWordBoundary Assertion = assertNotB         // \B
+	AssertNotDigit       Assertion = assertNotD         // \D
+	AssertNotSpace       Assertion = assertNotS         // \S
+	AssertNotWord        Assertion = assertNotW         // \W
+	AssertSpace          Assertion = assertS            // \s
+	AssertWord           Assertion = assertW            // \w
+)
+
+// String returns the regexp syntax of a.
+func (a Assertion) String() string { return assertString[int(a)] }
+
+// Prog returns the program of re. The result is a copy, modifying it does not
+// affect re.
+func (re *Regexp) Prog() *Prog {
+	p := &Prog{
+		Anchored: re.anchored,
+		Inst:     make([]Inst, len(re.prog)),
+		Raw:      re.raw,
+		Start:    re.start,
+		Start1:   re.start1,
+	}
+	for i, v := range re.prog {
+		in := &p.Inst[i]
+		in.Out = v.out
+		switch v.kind {
+		case opAccept:
+			in.Op = InstAccept
+		case opAssert:
+			in.Op = InstAssert
+			in.Arg = v.arg
+		case opChar:
+			in.Op = InstRune
+			in.Arg = v.arg
+		case opCharClass:
+			in.Op = InstClass
+			in.Ranges = re.ranges(&v)
+		case opDot:
+			in.Op = InstAny
+		case opDotNL:
+			in.Op = InstAnyNL
+		case opNop:
+			in.Op = InstNop
+		case opNotCharClass:
+			in.Op = InstNotClass
+			in.Ranges = re.ranges(&v)
+		case opSave:
+			in.Op = InstSave
+			in.Arg = v.arg
+		case opSplit:
+			in.Op = InstSplit
+			in.Out1 = v.out1
+		default:
+			panic("internal error")
+		}
+	}
+	return p
+}
+
+func (re *Regexp) ranges(q *instr) []rune {
+	r := make([]rune, q.arg2-q.arg)
+	for i := range r {
+		r[i] = rune(re.regs[q.arg+i])
+	}
+	return r
+}
@@ -0,0 +1,10 @@
+// Copyright 2017 The Regexp Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build race
+
+package regexp
+
+// sync.Pool drops objects at random when the race detector is enabled.
+const raceEnabled = true
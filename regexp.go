@@ -28,7 +28,8 @@
 //	http://swtch.com/~rsc/regexp/regexp1.html
 // or any book about automata theory.
 //
-// All characters are UTF-8-encoded code points.
+// All characters are UTF-8-encoded code points, except for Regexps
+// compiled by CompileRaw, which match bytes.
 //
 // There are 16 methods of Regexp that match a regular expression and identify
 // the matched text. Their names are matched by this regular expression:
@@ -92,14 +93,25 @@ const (
 	maxRepCount        = 1000 // Prevent x{1001}.
 )
 
-func compile(expr string, mode syntax.Flags, longest bool) (*Regexp, error) {
-	p := newParser(expr, newRegexp(expr))
+// Error describes a failure to parse a regular expression.
+type Error struct {
+	Msg    string // Description of the problem.
+	Offset int    // Byte offset in the expression where the problem was found.
+}
+
+func (e *Error) Error() string { return "error parsing regexp: " + e.Msg }
+
+func compile(expr string, mode syntax.Flags, longest, raw bool) (*Regexp, error) {
+	re := newRegexp(expr)
+	re.raw = raw
+	p := newParser(expr, re)
 	re, err := p.parse()
 	if err != nil {
 		return nil, err
 	}
 
 	re.longest = longest
+	re.posix = mode == syntax.POSIX
 	return re, nil
 }
 
@@ -112,7 +124,7 @@ func compile(expr string, mode syntax.Flags, longest bool) (*Regexp, error) {
 // matching is the same semantics that Perl, Python, and other implementations
 // use, although this package implements it without the expense of
 // backtracking. For POSIX leftmost-longest matching, see CompilePOSIX.
-func Compile(expr string) (*Regexp, error) { return compile(expr, syntax.Perl, false) }
+func Compile(expr string) (*Regexp, error) { return compile(expr, syntax.Perl, false, false) }
 
 // CompilePOSIX is like Compile but restricts the regular expression
 // to POSIX ERE (egrep) syntax and changes the match semantics to
@@ -133,7 +145,42 @@ func Compile(expr string) (*Regexp, error) { return compile(expr, syntax.Perl, f
 // subexpression, then the second, and so on from left to right.
 // The POSIX rule is computationally prohibitive and not even well-defined.
 // See http://swtch.com/~rsc/regexp/regexp2.html#posix for details.
-func CompilePOSIX(expr string) (*Regexp, error) { return compile(expr, syntax.POSIX, false) }
+func CompilePOSIX(expr string) (*Regexp, error) { return compile(expr, syntax.POSIX, false, false) }
+
+// CompileRaw is like Compile but the resulting Regexp matches its input byte
+// by byte instead of rune by rune, ie. with Latin-1 semantics. Every byte of
+// the input, including bytes not forming valid UTF-8, is one symbol and the
+// escapes \xHH and \x{HH} match exactly the byte 0xHH.
+//
+// Pattern runes up to U+00FF match the byte of the same value. Other runes
+// match their UTF-8 encoding and cannot be used in character classes.
+//
+// Text read from an io.RuneReader that does not implement io.ByteReader is
+// matched against the UTF-8 encoding of the runes it returns.
+func CompileRaw(expr string) (*Regexp, error) { return compile(expr, syntax.Perl, false, true) }
+
+// Match checks whether a textual regular expression matches a byte slice.
+// More complicated queries need to use Compile and the full Regexp interface.
+func Match(pattern string, b []byte) (matched bool, err error) {
+	re, err := Compile(pattern)
+	if err != nil {
+		return false, err
+	}
+
+	return re.Match(b), nil
+}
+
+// MatchReader checks whether a textual regular expression matches the text
+// read by the RuneReader. More complicated queries need to use Compile and
+// the full Regexp interface.
+func MatchReader(pattern string, r io.RuneReader) (matched bool, err error) {
+	re, err := Compile(pattern)
+	if err != nil {
+		return false, err
+	}
+
+	return re.MatchReader(r), nil
+}
 
 // MatchString checks whether a textual regular expression matches a string.
 // More complicated queries need to use Compile and the full Regexp interface.
@@ -178,24 +225,35 @@ func (re *Regexp) Find(b []byte) []byte {
 	return nil
 }
 
-func (re *Regexp) findAllIndex(rd io.RuneReader, n int) [][]int {
-	vm := newVM(re, rd)
-	var r [][]int
-	var prev []int
-	for vm.c != pastEOF && len(r) != n {
+// allMatches calls yield with at most n, all if n < 0, successive matches
+// found by vm, until yield returns false.
+func allMatches(vm *vm, n int, yield func([]int) bool) {
+	prev := [2]int{-1, -1} // Copied, vm may reuse a.
+	for vm.c != pastEOF && n != 0 {
 		a := vm.find()
 		if a == nil {
-			return r
+			return
 		}
 
 		// If 'All' is present, the routine matches successive
 		// non-overlapping matches of the entire expression.  Empty
 		// matches abutting a preceding match are ignored.
-		if prev == nil || a[0] != a[1] || prev[0] == prev[1] {
-			r = append(r, a[:2])
+		if prev[0] < 0 || a[0] != a[1] || prev[0] == prev[1] {
+			if !yield(a) {
+				return
+			}
+
+			n--
 		}
-		prev = a
+		prev = [2]int{a[0], a[1]}
 	}
+}
+
+func (re *Regexp) findAllIndex(vm *vm, n int) (r [][]int) {
+	allMatches(vm, n, func(a []int) bool {
+		r = append(r, a[:2])
+		return true
+	})
 	return r
 }
 
@@ -204,7 +262,7 @@ func (re *Regexp) findAllIndex(rd io.RuneReader, n int) [][]int {
 // package comment. A return value of nil indicates no match.
 func (re *Regexp) FindAll(b []byte, n int) [][]byte {
 	var r [][]byte
-	for _, a := range re.findAllIndex(bytes.NewReader(b), n) {
+	for _, a := range re.findAllIndex(newBytesVM(re, b), n) {
 		r = append(r, b[a[0]:a[1]])
 	}
 	return r
@@ -213,14 +271,14 @@ func (re *Regexp) FindAll(b []byte, n int) [][]byte {
 // FindAllIndex is the 'All' version of FindIndex; it returns a slice of all
 // successive matches of the expression, as defined by the 'All' description in
 // the package comment. A return value of nil indicates no match.
-func (re *Regexp) FindAllIndex(b []byte, n int) [][]int { return re.findAllIndex(bytes.NewReader(b), n) }
+func (re *Regexp) FindAllIndex(b []byte, n int) [][]int { return re.findAllIndex(newBytesVM(re, b), n) }
 
 // FindAllString is the 'All' version of FindString; it returns a slice of all
 // successive matches of the expression, as defined by the 'All' description in
 // the package comment. A return value of nil indicates no match.
 func (re *Regexp) FindAllString(s string, n int) []string {
 	var r []string
-	for _, a := range re.findAllIndex(strings.NewReader(s), n) {
+	for _, a := range re.findAllIndex(newStringVM(re, s), n) {
 		r = append(r, s[a[0]:a[1]])
 	}
 	return r
@@ -231,7 +289,7 @@ func (re *Regexp) FindAllString(s string, n int) []string {
 // description in the package comment. A return value of nil indicates no
 // match.
 func (re *Regexp) FindAllStringIndex(s string, n int) [][]int {
-	return re.findAllIndex(strings.NewReader(s), n)
+	return re.findAllIndex(newStringVM(re, s), n)
 }
 
 // FindAllStringSubmatch is the 'All' version of FindStringSubmatch; it returns
@@ -260,27 +318,14 @@ func (re *Regexp) FindAllStringSubmatch(s string, n int) [][]string {
 // by the 'All' description in the package comment. A return value of nil
 // indicates no match.
 func (re *Regexp) FindAllStringSubmatchIndex(s string, n int) [][]int {
-	return re.findAllSubmatchIndex(strings.NewReader(s), n)
+	return re.findAllSubmatchIndex(newStringVM(re, s), n)
 }
 
-func (re *Regexp) findAllSubmatchIndex(rd io.RuneReader, n int) [][]int {
-	vm := newVM(re, rd)
-	var r [][]int
-	var prev []int
-	for vm.c != pastEOF && len(r) != n {
-		a := vm.find()
-		if a == nil {
-			return r
-		}
-
-		// If 'All' is present, the routine matches successive
-		// non-overlapping matches of the entire expression.  Empty
-		// matches abutting a preceding match are ignored.
-		if prev == nil || a[0] != a[1] || prev[0] == prev[1] {
-			r = append(r, a)
-		}
-		prev = a
-	}
+func (re *Regexp) findAllSubmatchIndex(vm *vm, n int) (r [][]int) {
+	allMatches(vm, n, func(a []int) bool {
+		r = append(r, a)
+		return true
+	})
 	return r
 }
 
@@ -310,7 +355,7 @@ func (re *Regexp) FindAllSubmatch(b []byte, n int) [][][]byte {
 // description in the package comment. A return value of nil indicates no
 // match.
 func (re *Regexp) FindAllSubmatchIndex(b []byte, n int) [][]int {
-	return re.findAllSubmatchIndex(bytes.NewReader(b), n)
+	return re.findAllSubmatchIndex(newBytesVM(re, b), n)
 }
 
 // FindIndex returns a two-element slice of integers defining the location of
@@ -390,7 +435,7 @@ func (re *Regexp) FindStringSubmatch(s string) []string {
 // of its subexpressions, as defined by the 'Submatch' and 'Index' descriptions
 // in the package comment. A return value of nil indicates no match.
 func (re *Regexp) FindStringSubmatchIndex(s string) []int {
-	return newVM(re, strings.NewReader(s)).find()
+	return newStringVM(re, s).find()
 }
 
 // FindSubmatch returns a slice of slices holding the text of the leftmost
@@ -418,16 +463,100 @@ func (re *Regexp) FindSubmatch(b []byte) [][]byte {
 // leftmost match of the regular expression in b and the matches, if any, of
 // its subexpressions, as defined by the 'Submatch' and 'Index' descriptions in
 // the package comment. A return value of nil indicates no match.
-func (re *Regexp) FindSubmatchIndex(b []byte) []int { return newVM(re, bytes.NewReader(b)).find() }
+func (re *Regexp) FindSubmatchIndex(b []byte) []int { return newBytesVM(re, b).find() }
 
 // Match reports whether the Regexp matches the byte slice b.
 func (re *Regexp) Match(b []byte) bool {
-	return newVM(re, bytes.NewReader(b)).match()
+	return newBytesVM(re, b).match()
+}
+
+// MatchReader reports whether the Regexp matches the text read by the
+// RuneReader.
+func (re *Regexp) MatchReader(r io.RuneReader) bool {
+	return newVM(re, r).match()
 }
 
 // MatchString reports whether the Regexp matches the string s.
 func (re *Regexp) MatchString(s string) bool {
-	return newVM(re, strings.NewReader(s)).match()
+	return newStringVM(re, s).match()
+}
+
+// MatchFull reports whether the Regexp matches the whole byte slice b, as if
+// it was enclosed in ^(?:...)$, but without recompiling it. The search stops
+// as soon as no match can include the text read so far.
+func (re *Regexp) MatchFull(b []byte) bool {
+	vm := getVM(re, b, "", true)
+	r := vm.fullMatch()
+	vm.put()
+	return r
+}
+
+// MatchFullString is like MatchFull but matches the string s.
+func (re *Regexp) MatchFullString(s string) bool {
+	vm := getVM(re, nil, s, false)
+	r := vm.fullMatch()
+	vm.put()
+	return r
+}
+
+// MatchPrefix returns the length of the match of the Regexp starting at the
+// beginning of b, or -1 if there is no such match. The match is the one
+// FindIndex would return for the Regexp prefixed with ^, but without
+// recompiling it and without searching past the end of the match.
+func (re *Regexp) MatchPrefix(b []byte) int {
+	return re.matchPrefix(getVM(re, b, "", true))
+}
+
+// MatchPrefixString is like MatchPrefix but matches the string s.
+func (re *Regexp) MatchPrefixString(s string) int {
+	return re.matchPrefix(getVM(re, nil, s, false))
+}
+
+func (re *Regexp) matchPrefix(vm *vm) int {
+	vm.anchor = true
+	r := -1
+	if a := vm.find(); a != nil {
+		r = a[1]
+	}
+	vm.put()
+	return r
+}
+
+// MatchPartial reports whether the Regexp matches the whole byte slice b, like
+// MatchFull, and whether b is a prefix of a longer text it matches, ie.
+// whether b could still match if more text were appended.
+func (re *Regexp) MatchPartial(b []byte) (full, prefix bool) {
+	vm := getVM(re, b, "", true)
+	full, prefix = vm.partialMatch(true)
+	vm.put()
+	return full, prefix
+}
+
+// MatchPartialString is like MatchPartial but matches the string s.
+func (re *Regexp) MatchPartialString(s string) (full, prefix bool) {
+	vm := getVM(re, nil, s, false)
+	full, prefix = vm.partialMatch(true)
+	vm.put()
+	return full, prefix
+}
+
+// MatchPartialUnanchored reports whether b contains a match of the Regexp,
+// like Match, and whether a match starting in b can continue past its end, ie.
+// whether appending text to b can give a match including some of b.
+func (re *Regexp) MatchPartialUnanchored(b []byte) (full, prefix bool) {
+	vm := getVM(re, b, "", true)
+	full, prefix = vm.partialMatch(false)
+	vm.put()
+	return full, prefix
+}
+
+// MatchPartialUnanchoredString is like MatchPartialUnanchored but matches the
+// string s.
+func (re *Regexp) MatchPartialUnanchoredString(s string) (full, prefix bool) {
+	vm := getVM(re, nil, s, false)
+	full, prefix = vm.partialMatch(false)
+	vm.put()
+	return full, prefix
 }
 
 // NumSubexp returns the number of parenthesized subexpressions in this Regexp.
@@ -444,6 +573,21 @@ func (re *Regexp) SubexpNames() []string {
 	return re.groupNames
 }
 
+// SubexpIndex returns the index of the first subexpression with the given
+// name, or -1 if there is no subexpression with that name.
+//
+// Note that multiple subexpressions can be written using the same name, as in
+// (?P<bob>a+)(?P<bob>b+), which declares two subexpressions named "bob". In
+// this case, SubexpIndex returns the index of the leftmost such subexpression
+// in the regular expression.
+func (re *Regexp) SubexpIndex(name string) int {
+	if a := re.subexps[name]; len(a) != 0 {
+		return a[0]
+	}
+
+	return -1
+}
+
 // Split slices s into substrings separated by the expression and returns a slice of
 // the substrings between those expression matches.
 //
@@ -460,45 +604,111 @@ func (re *Regexp) SubexpNames() []string {
 //   n == 0: the result is nil (zero substrings)
 //   n < 0: all substrings
 func (re *Regexp) Split(s string, n int) []string {
-
-	if n == 0 {
+	pieces, _ := re.split(len(s), n, re.FindAllStringSubmatchIndex(s, n))
+	if pieces == nil {
 		return nil
 	}
 
-	if len(re.src) > 0 && len(s) == 0 {
-		return []string{""}
+	strings := make([]string, len(pieces))
+	for i, v := range pieces {
+		strings[i] = s[v[0]:v[1]]
+	}
+	return strings
+}
+
+// split returns the bounds of the pieces of a text of length size separated
+// by matches, as described for Split, and the matches following the pieces.
+func (re *Regexp) split(size, n int, matches [][]int) (pieces [][2]int, delims [][]int) {
+	if n == 0 {
+		return nil, nil
 	}
 
-	matches := re.FindAllStringIndex(s, n)
-	strings := make([]string, 0, len(matches))
+	if len(re.src) > 0 && size == 0 {
+		return [][2]int{{0, 0}}, nil
+	}
 
+	pieces = make([][2]int, 0, len(matches))
 	beg := 0
 	end := 0
 	for _, match := range matches {
-		if n > 0 && len(strings) >= n-1 {
+		if n > 0 && len(pieces) >= n-1 {
 			break
 		}
 
 		end = match[0]
 		if match[1] != 0 {
-			strings = append(strings, s[beg:end])
+			pieces = append(pieces, [2]int{beg, end})
+			delims = append(delims, match)
 		}
 		beg = match[1]
 	}
 
-	if end != len(s) {
-		strings = append(strings, s[beg:])
+	if end != size {
+		pieces = append(pieces, [2]int{beg, size})
+	}
+
+	return pieces, delims
+}
+
+// SplitBytes is like Split but slices b.
+func (re *Regexp) SplitBytes(b []byte, n int) [][]byte {
+	pieces, _ := re.split(len(b), n, re.FindAllSubmatchIndex(b, n))
+	if pieces == nil {
+		return nil
 	}
 
+	r := make([][]byte, len(pieces))
+	for i, v := range pieces {
+		r[i] = b[v[0]:v[1]:v[1]]
+	}
+	return r
+}
+
+// SplitAfter is like Split but every substring keeps the expression match
+// following it, if any.
+//
+// Example:
+//   s := regexp.MustCompile(",+").SplitAfter("a,b,,c", -1)
+//   // s: ["a,", "b,,", "c"]
+func (re *Regexp) SplitAfter(s string, n int) []string {
+	pieces, delims := re.split(len(s), n, re.FindAllStringSubmatchIndex(s, n))
+	if pieces == nil {
+		return nil
+	}
+
+	strings := make([]string, len(pieces))
+	for i, v := range pieces {
+		if i < len(delims) {
+			v[1] = delims[i][1]
+		}
+		strings[i] = s[v[0]:v[1]]
+	}
 	return strings
 }
 
+// SplitWithDelims is like Split but returns also the expression matches
+// separating the substrings, as defined by the 'Submatch' and 'Index'
+// descriptions in the package comment. The substring pieces[i] is followed by
+// the match delims[i], if any.
+func (re *Regexp) SplitWithDelims(s string, n int) (pieces []string, delims [][]int) {
+	bounds, delims := re.split(len(s), n, re.FindAllStringSubmatchIndex(s, n))
+	if bounds == nil {
+		return nil, nil
+	}
+
+	pieces = make([]string, len(bounds))
+	for i, v := range bounds {
+		pieces[i] = s[v[0]:v[1]]
+	}
+	return pieces, delims
+}
+
 // ReplaceAllLiteralString returns a copy of src, replacing matches of the Regexp
 // with the replacement string repl. The replacement repl is substituted directly,
 // without using Expand.
 func (re *Regexp) ReplaceAllLiteralString(src, repl string) string {
 	var out buffer.Bytes
-	vm := newVM(re, strings.NewReader(src))
+	vm := newStringVM(re, src)
 	pos := 0
 	var prev []int
 	for vm.c != pastEOF {
@@ -531,7 +741,7 @@ func (re *Regexp) ReplaceAllLiteralString(src, repl string) string {
 // without using Expand.
 func (re *Regexp) ReplaceAllLiteral(src, repl []byte) []byte {
 	var out buffer.Bytes
-	vm := newVM(re, bytes.NewReader(src))
+	vm := newBytesVM(re, src)
 	pos := 0
 	var prev []int
 	for vm.c != pastEOF {
@@ -563,67 +773,16 @@ func (re *Regexp) ReplaceAllLiteral(src, repl []byte) []byte {
 // with the replacement string repl. Inside repl, $ signs are interpreted as
 // in Expand, so for instance $1 represents the text of the first submatch.
 func (re *Regexp) ReplaceAllString(src, repl string) string {
-	var out buffer.Bytes
-	vm := newVM(re, strings.NewReader(src))
-	pos := 0
-	var prev []int
-	for vm.c != pastEOF {
-		a := vm.find()
-		if a == nil {
-			break
-		}
-
-		// If 'All' is present, the routine matches successive
-		// non-overlapping matches of the entire expression.  Empty
-		// matches abutting a preceding match are ignored.
-		if prev == nil || a[0] != a[1] || prev[0] == prev[1] {
-			first := a[0]
-			if pos < first {
-				out.WriteString(src[pos:first])
-			}
-			out.Write(re.expand(nil, repl, nil, src, a))
-			pos = a[1]
-		}
-		prev = a
-	}
-	if pos < len(src) {
-		out.WriteString(src[pos:])
-	}
-	return string(out.Bytes())
+	t, _ := re.compileTemplate(repl, 0, false)
+	return re.ReplaceAllStringTemplate(src, t)
 }
 
 // ReplaceAll returns a copy of src, replacing matches of the Regexp
 // with the replacement text repl. Inside repl, $ signs are interpreted as
 // in Expand, so for instance $1 represents the text of the first submatch.
 func (re *Regexp) ReplaceAll(src, repl []byte) []byte {
-	srepl := string(repl)
-	var out buffer.Bytes
-	vm := newVM(re, bytes.NewReader(src))
-	pos := 0
-	var prev []int
-	for vm.c != pastEOF {
-		a := vm.find()
-		if a == nil {
-			break
-		}
-
-		// If 'All' is present, the routine matches successive
-		// non-overlapping matches of the entire expression.  Empty
-		// matches abutting a preceding match are ignored.
-		if prev == nil || a[0] != a[1] || prev[0] == prev[1] {
-			first := a[0]
-			if pos < first {
-				out.Write(src[pos:first])
-			}
-			out.Write(re.expand(nil, srepl, src, "", a))
-			pos = a[1]
-		}
-		prev = a
-	}
-	if pos < len(src) {
-		out.Write(src[pos:])
-	}
-	return out.Bytes()
+	t, _ := re.compileTemplate(string(repl), 0, false)
+	return re.ReplaceAllTemplate(src, t)
 }
 
 // ReplaceAllStringFunc returns a copy of src in which all matches of the
@@ -632,7 +791,7 @@ func (re *Regexp) ReplaceAll(src, repl []byte) []byte {
 // directly, without using Expand.
 func (re *Regexp) ReplaceAllStringFunc(src string, repl func(string) string) string {
 	var out buffer.Bytes
-	vm := newVM(re, strings.NewReader(src))
+	vm := newStringVM(re, src)
 	pos := 0
 	var prev []int
 	for vm.c != pastEOF {
@@ -666,7 +825,7 @@ func (re *Regexp) ReplaceAllStringFunc(src string, repl func(string) string) str
 // directly, without using Expand.
 func (re *Regexp) ReplaceAllFunc(src []byte, repl func([]byte) []byte) []byte {
 	var out buffer.Bytes
-	vm := newVM(re, bytes.NewReader(src))
+	vm := newBytesVM(re, src)
 	pos := 0
 	var prev []int
 	for vm.c != pastEOF {
@@ -694,6 +853,72 @@ func (re *Regexp) ReplaceAllFunc(src []byte, repl func([]byte) []byte) []byte {
 	return out.Bytes()
 }
 
+// ReplaceAllSubmatchFunc returns a copy of src in which at most n, all if
+// n < 0, successive matches of the Regexp have been replaced by the return
+// value of function repl applied to the match. The match slice holds the
+// index pairs of the match and its submatches in src, as returned by
+// FindSubmatchIndex, and is valid only until repl returns. The replacement
+// returned by repl is substituted directly, without using Expand. If repl
+// returns an error, ReplaceAllSubmatchFunc stops and returns that error.
+func (re *Regexp) ReplaceAllSubmatchFunc(src []byte, n int, repl func(match []int) ([]byte, error)) ([]byte, error) {
+	var out buffer.Bytes
+	pos := 0
+	var err error
+	allMatches(newBytesVM(re, src), n, func(a []int) bool {
+		var b []byte
+		if b, err = repl(a); err != nil {
+			return false
+		}
+
+		out.Write(src[pos:a[0]])
+		out.Write(b)
+		pos = a[1]
+		return true
+	})
+	if err != nil {
+		out.Close()
+		return nil, err
+	}
+
+	out.Write(src[pos:])
+	return out.Bytes(), nil
+}
+
+// ReplaceAllStringSubmatchFunc is like ReplaceAllSubmatchFunc but the source
+// and the replacements are strings. Function repl gets also the text of the
+// match and its submatches, as returned by FindStringSubmatch, in a slice
+// valid only until repl returns.
+func (re *Regexp) ReplaceAllStringSubmatchFunc(src string, n int, repl func(groups []string, match []int) (string, error)) (string, error) {
+	var out buffer.Bytes
+	pos := 0
+	groups := make([]string, 1+re.NumSubexp())
+	var err error
+	allMatches(newStringVM(re, src), n, func(a []int) bool {
+		for i := range groups {
+			groups[i] = ""
+			if 2*i < len(a) && a[2*i] >= 0 {
+				groups[i] = src[a[2*i]:a[2*i+1]]
+			}
+		}
+		var s string
+		if s, err = repl(groups, a); err != nil {
+			return false
+		}
+
+		out.WriteString(src[pos:a[0]])
+		out.WriteString(s)
+		pos = a[1]
+		return true
+	})
+	if err != nil {
+		out.Close()
+		return "", err
+	}
+
+	out.WriteString(src[pos:])
+	return string(out.Bytes()), nil
+}
+
 // Expand appends template to dst and returns the result; during the
 // append, Expand replaces variables in the template with corresponding
 // matches drawn from src. The match slice should have been returned by
@@ -806,8 +1031,8 @@ func (re *Regexp) expand(dst []byte, template string, bsrc []byte, src string, m
 				}
 			}
 		} else {
-			for i, namei := range re.groupNames {
-				if name == namei && 2*i+1 < len(match) && match[2*i] >= 0 {
+			for _, i := range re.subexps[name] {
+				if 2*i+1 < len(match) && match[2*i] >= 0 {
 					if bsrc != nil {
 						dst = append(dst, bsrc[match[2*i]:match[2*i+1]]...)
 					} else {
@@ -847,6 +1072,17 @@ func MustCompile(str string) *Regexp {
 	return regexp
 }
 
+// MustCompileRaw is like CompileRaw but panics if the expression cannot be
+// parsed. It simplifies safe initialization of global variables holding
+// compiled regular expressions.
+func MustCompileRaw(str string) *Regexp {
+	regexp, error := CompileRaw(str)
+	if error != nil {
+		panic(`regexp: CompileRaw(` + quote(str) + `): ` + error.Error())
+	}
+	return regexp
+}
+
 // MustCompilePOSIX is like CompilePOSIX but panics if the expression cannot be parsed.
 // It simplifies safe initialization of global variables holding compiled regular
 // expressions.
@@ -0,0 +1,223 @@
+// Copyright 2017 The Regexp Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package regexp
+
+// reverseProg is the program of a Regexp with all edges reversed. It has no
+// opSave or opNop states. Assertions keep their forward meaning.
+//
+// It is built for patterns anchored at the end of text only, which find scans
+// backward from the end of the input. Finding the start of other matches
+// backward from their end would need a forward pass reporting where the
+// leftmost-first match ends, which the bit parallel engine cannot tell.
+type reverseProg struct {
+	prog  []instr
+	start int
+}
+
+// reversible reports whether the program reachable from re.start can be
+// executed backwards.
+func (re *Regexp) reversible() bool {
+	for _, s := range re.states() {
+		p := &re.prog[s]
+		switch p.kind {
+		case opAssert:
+			switch p.arg {
+			case assertBOT, assertEOT:
+				// ok
+			default:
+				return false
+			}
+		case opCharClass, opNotCharClass:
+			for i := p.arg; i < p.arg2; i += 2 {
+				switch re.regs[i] {
+				case -assertD, -assertS, -assertW:
+					// ok
+				default:
+					if re.regs[i] < 0 {
+						return false
+					}
+				}
+			}
+		}
+	}
+	return true
+}
+
+// states returns the states reachable from re.start.
+func (re *Regexp) states() []int {
+	seen := make([]bool, len(re.prog))
+	r := []int{re.start}
+	seen[re.start] = true
+	for i := 0; i < len(r); i++ {
+		out, out1 := re.edges(r[i])
+		for _, t := range []int{out, out1} {
+			if t >= 0 && !seen[t] {
+				seen[t] = true
+				r = append(r, t)
+			}
+		}
+	}
+	return r
+}
+
+// getReverse computes re.reverse if re is anchored at the end of text, ie.
+// any match ends with \z, and the program is reversible.
+func (re *Regexp) getReverse() *Regexp {
+	if noOpt || re.anchored || !re.reversible() {
+		return re
+	}
+
+	// Map the consuming and asserting states reachable from re.start to
+	// states of the reverse program. State 0 of the reverse program is
+	// its opAccept.
+	rev := make([]int, len(re.prog))
+	p := &reverseProg{prog: []instr{{kind: opAccept}}}
+	var nodes []int
+	for _, s := range re.states() {
+		switch re.prog[s].kind {
+		case opAccept, opNop, opSave, opSplit:
+			continue
+		}
+
+		rev[s] = len(p.prog)
+		p.prog = append(p.prog, re.prog[s])
+		nodes = append(nodes, s)
+	}
+
+	// preds[s] lists the reverse states a path can continue with after
+	// reverse state rev[s], or after the reverse start for s == re.accept.
+	preds := make([][]int, len(re.prog))
+	seen := make([]int, len(re.prog))
+	gen := 0
+	var f func(int, int)
+	f = func(s, from int) {
+		if seen[s] == gen {
+			return
+		}
+
+		seen[s] = gen
+		switch q := &re.prog[s]; q.kind {
+		case opNop, opSave:
+			f(q.out, from)
+		case opSplit:
+			f(q.out, from)
+			f(q.out1, from)
+		default:
+			preds[s] = append(preds[s], from)
+		}
+	}
+	gen++
+	f(re.start, 0)
+	for _, s := range nodes {
+		gen++
+		f(re.prog[s].out, rev[s])
+	}
+
+	// Any match must end with \z.
+	if len(preds[re.accept]) == 0 {
+		return re
+	}
+
+	for _, r := range preds[re.accept] {
+		if q := &p.prog[r]; r == 0 || q.kind != opAssert || q.arg != assertEOT {
+			return re
+		}
+	}
+
+	fork := func(a []int) int {
+		switch len(a) {
+		case 0:
+			return -1
+		case 1:
+			return a[0]
+		}
+
+		r := a[len(a)-1]
+		for i := len(a) - 2; i >= 0; i-- {
+			p.prog = append(p.prog, instr{kind: opSplit, out: a[i], out1: r})
+			r = len(p.prog) - 1
+		}
+		return r
+	}
+	for _, s := range nodes {
+		p.prog[rev[s]].out = fork(preds[s])
+	}
+	p.start = fork(preds[re.accept])
+	re.reverse = p
+	return re
+}
+
+// reverseStart returns the smallest position at or after vm.pos where a
+// match ending at the end of the input starts, or -1 if there is no such
+// position. The VM must have a string or []byte input and re.reverse must
+// not be nil.
+func (vm *vm) reverseStart() int {
+	p := vm.re.reverse
+	if l := vm.rlists[0]; l == nil || len(l.dense) < len(p.prog) {
+		vm.rlists = [2]*threadList{newThreadList(len(p.prog)), newThreadList(len(p.prog))}
+	}
+	clist, nlist := vm.rlists[0], vm.rlists[1]
+	clist.len = 0
+	n := len(vm.src)
+	if vm.isBytes {
+		n = len(vm.bsrc)
+	}
+	r := -1
+	pos := n
+	var add func(*threadList, int)
+	add = func(l *threadList, pc int) {
+		if pc < 0 || l.has(pc) {
+			return
+		}
+
+		l.include(thread{pc: pc})
+		switch q := &p.prog[pc]; q.kind {
+		case opAccept:
+			r = pos
+		case opAssert:
+			if q.arg == assertBOT && pos == 0 || q.arg == assertEOT && pos == n {
+				add(l, q.out)
+			}
+		case opSplit:
+			add(l, q.out)
+			add(l, q.out1)
+		}
+	}
+	add(clist, p.start)
+	for clist.len != 0 && pos > vm.pos {
+		c, sz := vm.lastRune(pos)
+		pos -= sz
+		nlist.len = 0
+		for i := 0; i < clist.len; i++ {
+			pc := clist.dense[i].pc
+			switch q := &p.prog[pc]; q.kind {
+			case opChar:
+				if c == rune(q.arg) {
+					add(nlist, q.out)
+				}
+			case opCharClass:
+				if inSet(vm.re.regs[q.arg:q.arg2], c) {
+					add(nlist, q.out)
+				}
+			case opNotCharClass:
+				if !inSet(vm.re.regs[q.arg:q.arg2], c) {
+					add(nlist, q.out)
+				}
+			case opDot:
+				if c != '\n' {
+					add(nlist, q.out)
+				}
+			case opDotNL:
+				add(nlist, q.out)
+			}
+		}
+		clist, nlist = nlist, clist
+	}
+	if r < vm.pos {
+		return -1
+	}
+
+	return r
+}
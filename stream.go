@@ -0,0 +1,164 @@
+// Copyright 2017 The Regexp Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package regexp
+
+import (
+	"bufio"
+	"io"
+	"unicode/utf8"
+)
+
+const streamTrim = 4096 // Minimum growth of stream.buf before trimming it.
+
+// stream is the input and output of a streaming replace. It keeps the input
+// read but not yet written, starting at offset base.
+type stream struct {
+	base int
+	buf  []byte
+	err  error // First read error other than io.EOF or first write error.
+	next int   // Trim when len(buf) reaches this.
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+func newStream(dst io.Writer, src io.Reader) *stream {
+	return &stream{
+		next: streamTrim,
+		r:    bufio.NewReader(src),
+		w:    bufio.NewWriter(dst),
+	}
+}
+
+func (s *stream) ReadRune() (rune, int, error) {
+	c, n, err := s.r.ReadRune()
+	if err != nil {
+		if err != io.EOF && s.err == nil {
+			s.err = err
+		}
+		return c, n, err
+	}
+
+	if c == utf8.RuneError && n == 1 {
+		// Keep the invalid byte as is.
+		s.r.UnreadRune()
+		b, _ := s.r.ReadByte()
+		s.buf = append(s.buf, b)
+		return c, n, nil
+	}
+
+	s.buf = append(s.buf, string(c)...)
+	return c, n, nil
+}
+
+// ReadByte is used by raw regexps only.
+func (s *stream) ReadByte() (byte, error) {
+	b, err := s.r.ReadByte()
+	if err != nil {
+		if err != io.EOF && s.err == nil {
+			s.err = err
+		}
+		return b, err
+	}
+
+	s.buf = append(s.buf, b)
+	return b, nil
+}
+
+// trim writes the input no match can include anymore.
+func (s *stream) trim(vm *vm, l *threadList) {
+	if len(s.buf) < s.next {
+		return
+	}
+
+	if n := vm.lowWater(l); n > s.base {
+		s.write(n)
+	}
+	s.next = len(s.buf) + streamTrim
+}
+
+// write writes the input up to offset n.
+func (s *stream) write(n int) {
+	s.writeBytes(s.buf[:n-s.base])
+	s.drop(n)
+}
+
+func (s *stream) writeBytes(b []byte) {
+	if _, err := s.w.Write(b); err != nil && s.err == nil {
+		s.err = err
+	}
+}
+
+// drop discards the input up to offset n.
+func (s *stream) drop(n int) {
+	s.buf = append(s.buf[:0], s.buf[n-s.base:]...)
+	s.base = n
+}
+
+// replace writes the input and the output of repl for every match a, which
+// gets the input starting at offset base.
+func (s *stream) replace(re *Regexp, repl func(dst []byte, a []int, base int) []byte) error {
+	vm := newVM(re, s)
+	vm.trim = s.trim
+	var prev []int
+	var b []byte
+	for vm.c != pastEOF && s.err == nil {
+		a := vm.find()
+		if a == nil {
+			break
+		}
+
+		// If 'All' is present, the routine matches successive
+		// non-overlapping matches of the entire expression.  Empty
+		// matches abutting a preceding match are ignored.
+		if prev == nil || a[0] != a[1] || prev[0] == prev[1] {
+			s.write(a[0])
+			b = repl(b[:0], a, s.base)
+			s.writeBytes(b)
+			s.drop(a[1])
+		}
+		prev = a
+	}
+	if s.err != nil {
+		return s.err
+	}
+
+	s.writeBytes(s.buf)
+	if _, err := s.r.WriteTo(s.w); err != nil && s.err == nil {
+		s.err = err
+	}
+	if err := s.w.Flush(); err != nil && s.err == nil {
+		s.err = err
+	}
+	return s.err
+}
+
+// ReplaceAllReader writes to dst a copy of the text read from src, replacing
+// matches of the Regexp with the replacement text repl. Inside repl, $ signs
+// are interpreted as in Expand. Only the text a match may still include is
+// kept in memory, so src may be arbitrarily long. The result is the first
+// error reading src or writing dst.
+func (re *Regexp) ReplaceAllReader(dst io.Writer, src io.Reader, repl []byte) error {
+	t, _ := re.compileTemplate(string(repl), 0, false)
+	return re.ReplaceAllTemplateReader(dst, src, t)
+}
+
+// ReplaceAllLiteralReader is like ReplaceAllReader but repl is substituted
+// directly, without using Expand.
+func (re *Regexp) ReplaceAllLiteralReader(dst io.Writer, src io.Reader, repl []byte) error {
+	return newStream(dst, src).replace(re, func(b []byte, a []int, base int) []byte {
+		return append(b, repl...)
+	})
+}
+
+// ReplaceAllFuncReader is like ReplaceAllReader but the matches are replaced
+// by the return value of function repl applied to the matched byte slice,
+// which is valid only until repl returns. The replacement returned by repl is
+// substituted directly, without using Expand.
+func (re *Regexp) ReplaceAllFuncReader(dst io.Writer, src io.Reader, repl func([]byte) []byte) error {
+	s := newStream(dst, src)
+	return s.replace(re, func(b []byte, a []int, base int) []byte {
+		return append(b, repl(s.buf[a[0]-base:a[1]-base])...)
+	})
+}
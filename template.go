@@ -0,0 +1,396 @@
+// Copyright 2017 The Regexp Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package regexp
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/cznic/internal/buffer"
+)
+
+// TemplateMode selects extensions of the template syntax.
+type TemplateMode int
+
+// Template modes.
+const (
+	// CaseEscapes enables the case conversion escapes of sed and Perl.
+	// \U and \L convert the rest of the expansion, or the part up to the
+	// next \U, \L or \E, to upper and lower case. \u and \l convert
+	// the next rune of the expansion to title and lower case, after any
+	// \U or \L conversion. \\ is a literal backslash. Other backslashes
+	// are literal. The conversions apply to literal text and expanded
+	// variables alike and use the Unicode case mappings.
+	CaseEscapes TemplateMode = 1 << iota
+)
+
+// Case conversions.
+const (
+	caseNone = iota
+	caseEnd
+	caseLower
+	caseLowerNext
+	caseUpper
+	caseUpperNext
+)
+
+// Template is a replacement template compiled for a Regexp. Its syntax is
+// described at Regexp.Expand. Expanding a Template does not parse it again
+// and does not allocate other than to grow the destination. A Template is
+// safe for concurrent use by multiple goroutines.
+type Template struct {
+	cased bool // Some item has a case conversion.
+	items []templateItem
+	re    *Regexp
+	src   string
+}
+
+// templateItem is a case conversion, if any, followed by a literal followed
+// by the first participating group of groups, if any.
+type templateItem struct {
+	conv   int
+	groups []int
+	lit    string
+}
+
+// CompileTemplate compiles template for use with re. Unlike Expand, it
+// reports references to groups re does not have as errors.
+func (re *Regexp) CompileTemplate(template string) (*Template, error) {
+	return re.compileTemplate(template, 0, true)
+}
+
+// CompileTemplateMode is like CompileTemplate but accepts the extensions of
+// the template syntax selected by mode.
+//
+// Example:
+//   re := regexp.MustCompile(`([A-Za-z]+)_([A-Za-z]+)`)
+//   t := re.MustCompileTemplateMode(`\L${1}\u$2`, regexp.CaseEscapes)
+//   s := re.ReplaceAllStringTemplate("FOO_bar", t)
+//   // s: "fooBar"
+func (re *Regexp) CompileTemplateMode(template string, mode TemplateMode) (*Template, error) {
+	return re.compileTemplate(template, mode, true)
+}
+
+// MustCompileTemplate is like CompileTemplate but panics if the template
+// refers to groups re does not have.
+func (re *Regexp) MustCompileTemplate(template string) *Template {
+	t, err := re.CompileTemplate(template)
+	if err != nil {
+		panic(`regexp: CompileTemplate(` + quote(template) + `): ` + err.Error())
+	}
+	return t
+}
+
+// MustCompileTemplateMode is like CompileTemplateMode but panics if the
+// template refers to groups re does not have.
+func (re *Regexp) MustCompileTemplateMode(template string, mode TemplateMode) *Template {
+	t, err := re.CompileTemplateMode(template, mode)
+	if err != nil {
+		panic(`regexp: CompileTemplateMode(` + quote(template) + `): ` + err.Error())
+	}
+	return t
+}
+
+// compileTemplate compiles template. If strict is false, references to groups
+// re does not have expand to nothing like in Expand.
+func (re *Regexp) compileTemplate(template string, mode TemplateMode, strict bool) (*Template, error) {
+	t := &Template{re: re, src: template}
+	special := "$"
+	if mode&CaseEscapes != 0 {
+		special = "$\\"
+	}
+	var lit []byte
+	conv := caseNone
+	for len(template) > 0 {
+		i := strings.IndexAny(template, special)
+		if i < 0 {
+			break
+		}
+		lit = append(lit, template[:i]...)
+		template = template[i:]
+		if template[0] == '\\' {
+			c := caseNone
+			if len(template) > 1 {
+				switch template[1] {
+				case '\\':
+					lit = append(lit, '\\')
+					template = template[2:]
+					continue
+				case 'E':
+					c = caseEnd
+				case 'L':
+					c = caseLower
+				case 'l':
+					c = caseLowerNext
+				case 'U':
+					c = caseUpper
+				case 'u':
+					c = caseUpperNext
+				}
+			}
+			if c == caseNone {
+				lit = append(lit, '\\')
+				template = template[1:]
+				continue
+			}
+
+			template = template[2:]
+			if conv != caseNone || len(lit) != 0 {
+				t.items = append(t.items, templateItem{conv: conv, lit: string(lit)})
+				lit = lit[:0]
+			}
+			conv = c
+			t.cased = true
+			continue
+		}
+
+		if len(template) > 1 && template[1] == '$' {
+			// Treat $$ as $.
+			lit = append(lit, '$')
+			template = template[2:]
+			continue
+		}
+		name, num, rest, ok := extract(template)
+		if !ok {
+			// Malformed; treat $ as raw text.
+			lit = append(lit, '$')
+			template = template[1:]
+			continue
+		}
+		template = rest
+		var groups []int
+		switch {
+		case num >= 0:
+			if num >= re.groups {
+				if strict {
+					return nil, fmt.Errorf("regexp: template refers to group %d, the regexp has %d", num, re.groups-1)
+				}
+
+				continue
+			}
+
+			groups = []int{num}
+		default:
+			if groups = re.subexps[name]; groups == nil {
+				if strict {
+					return nil, fmt.Errorf("regexp: template refers to unknown group %q", name)
+				}
+
+				continue
+			}
+		}
+		t.items = append(t.items, templateItem{conv: conv, groups: groups, lit: string(lit)})
+		lit = lit[:0]
+		conv = caseNone
+	}
+	if lit = append(lit, template...); conv != caseNone || len(lit) != 0 {
+		t.items = append(t.items, templateItem{conv: conv, lit: string(lit)})
+	}
+	return t, nil
+}
+
+// String returns the source text of t.
+func (t *Template) String() string { return t.src }
+
+// Expand appends t to dst and returns the result; during the append, Expand
+// replaces variables in the template with corresponding matches drawn from
+// src. The match slice should have been returned by FindSubmatchIndex of the
+// Regexp t was compiled for.
+func (t *Template) Expand(dst []byte, src []byte, match []int) []byte {
+	return t.expand(dst, src, "", match)
+}
+
+// ExpandString is like Expand but the source is a string.
+func (t *Template) ExpandString(dst []byte, src string, match []int) []byte {
+	return t.expand(dst, nil, src, match)
+}
+
+func (t *Template) expand(dst []byte, bsrc []byte, src string, match []int) []byte {
+	if t.cased {
+		return t.expandCase(dst, bsrc, src, match)
+	}
+
+	for _, v := range t.items {
+		dst = append(dst, v.lit...)
+		for _, i := range v.groups {
+			if 2*i+1 < len(match) && match[2*i] >= 0 {
+				if bsrc != nil {
+					dst = append(dst, bsrc[match[2*i]:match[2*i+1]]...)
+				} else {
+					dst = append(dst, src[match[2*i]:match[2*i+1]]...)
+				}
+				break
+			}
+		}
+	}
+	return dst
+}
+
+// expandCase is like expand for templates with case conversions.
+func (t *Template) expandCase(dst []byte, bsrc []byte, src string, match []int) []byte {
+	c := &caseConv{}
+	for _, v := range t.items {
+		switch v.conv {
+		case caseEnd:
+			c.mode = caseNone
+		case
+			caseLower,
+			caseUpper:
+
+			c.mode = v.conv
+		case
+			caseLowerNext,
+			caseUpperNext:
+
+			c.next = v.conv
+		}
+		dst = c.appendString(dst, v.lit)
+		for _, i := range v.groups {
+			if 2*i+1 < len(match) && match[2*i] >= 0 {
+				if bsrc != nil {
+					dst = c.appendBytes(dst, bsrc[match[2*i]:match[2*i+1]])
+				} else {
+					dst = c.appendString(dst, src[match[2*i]:match[2*i+1]])
+				}
+				break
+			}
+		}
+	}
+	return dst
+}
+
+// caseConv is the state of case conversion during expansion.
+type caseConv struct {
+	mode int // caseNone, caseLower or caseUpper.
+	next int // caseNone, caseLowerNext or caseUpperNext.
+}
+
+func (c *caseConv) appendString(dst []byte, s string) []byte {
+	for len(s) != 0 {
+		r, n := utf8.DecodeRuneInString(s)
+		dst = c.appendRune(dst, r, s[:n])
+		s = s[n:]
+	}
+	return dst
+}
+
+func (c *caseConv) appendBytes(dst []byte, b []byte) []byte {
+	for len(b) != 0 {
+		r, n := utf8.DecodeRune(b)
+		dst = c.appendRune(dst, r, string(b[:n]))
+		b = b[n:]
+	}
+	return dst
+}
+
+// appendRune appends r converted, or its encoding s if it is not converted.
+func (c *caseConv) appendRune(dst []byte, r rune, s string) []byte {
+	if c.mode == caseNone && c.next == caseNone || r == utf8.RuneError && len(s) == 1 {
+		return append(dst, s...)
+	}
+
+	switch c.mode {
+	case caseLower:
+		r = unicode.ToLower(r)
+	case caseUpper:
+		r = unicode.ToUpper(r)
+	}
+	switch c.next {
+	case caseLowerNext:
+		r = unicode.ToLower(r)
+	case caseUpperNext:
+		r = unicode.ToTitle(r)
+	}
+	c.next = caseNone
+	var a [utf8.UTFMax]byte
+	return append(dst, a[:utf8.EncodeRune(a[:], r)]...)
+}
+
+// ReplaceAllTemplate is like ReplaceAll but uses a compiled template. The
+// template must have been compiled for re.
+func (re *Regexp) ReplaceAllTemplate(src []byte, t *Template) []byte {
+	var out buffer.Bytes
+	vm := newBytesVM(re, src)
+	pos := 0
+	var prev []int
+	var b []byte
+	for vm.c != pastEOF {
+		a := vm.find()
+		if a == nil {
+			break
+		}
+
+		// If 'All' is present, the routine matches successive
+		// non-overlapping matches of the entire expression.  Empty
+		// matches abutting a preceding match are ignored.
+		if prev == nil || a[0] != a[1] || prev[0] == prev[1] {
+			first := a[0]
+			if pos < first {
+				out.Write(src[pos:first])
+			}
+			b = t.expand(b[:0], src, "", a)
+			out.Write(b)
+			pos = a[1]
+		}
+		prev = a
+	}
+	if pos < len(src) {
+		out.Write(src[pos:])
+	}
+	return out.Bytes()
+}
+
+// ReplaceAllStringTemplate is like ReplaceAllString but uses a compiled
+// template. The template must have been compiled for re.
+func (re *Regexp) ReplaceAllStringTemplate(src string, t *Template) string {
+	var out buffer.Bytes
+	vm := newStringVM(re, src)
+	pos := 0
+	var prev []int
+	var b []byte
+	for vm.c != pastEOF {
+		a := vm.find()
+		if a == nil {
+			break
+		}
+
+		// If 'All' is present, the routine matches successive
+		// non-overlapping matches of the entire expression.  Empty
+		// matches abutting a preceding match are ignored.
+		if prev == nil || a[0] != a[1] || prev[0] == prev[1] {
+			first := a[0]
+			if pos < first {
+				out.WriteString(src[pos:first])
+			}
+			b = t.expand(b[:0], nil, src, a)
+			out.Write(b)
+			pos = a[1]
+		}
+		prev = a
+	}
+	if pos < len(src) {
+		out.WriteString(src[pos:])
+	}
+	return string(out.Bytes())
+}
+
+// ReplaceAllTemplateReader is like ReplaceAllReader but uses a compiled
+// template. The template must have been compiled for re.
+func (re *Regexp) ReplaceAllTemplateReader(dst io.Writer, src io.Reader, t *Template) error {
+	s := newStream(dst, src)
+	var loc []int
+	return s.replace(re, func(b []byte, a []int, base int) []byte {
+		loc = append(loc[:0], a...)
+		for i, v := range loc {
+			if v >= 0 {
+				loc[i] = v - base
+			}
+		}
+		return t.expand(b, s.buf, "", loc)
+	})
+}
@@ -5,15 +5,22 @@
 package regexp
 
 import (
+	"bytes"
 	"io"
+	"strings"
+	"sync"
+	"unicode/utf8"
 )
 
+// vmPool keeps VMs whose results were copied out, see getVM.
+var vmPool = sync.Pool{New: func() interface{} { return &vm{} }}
+
 type submatches struct {
 	sub []int
 }
 
-func (s *submatches) update(i, pos, nsub int) submatches {
-	sub := make([]int, nsub)
+func (s *submatches) update(vm *vm, i, pos int) submatches {
+	sub := vm.newSub()
 	for i := range sub {
 		sub[i] = -1
 	}
@@ -56,15 +63,33 @@ func (l *threadList) has(pc int) bool {
 }
 
 type vm struct {
-	re     *Regexp
-	r      io.RuneReader
-	saved  []int
-	pos    int
-	sz     int
-	last   rune
-	c      rune
-	first  bool
-	closed bool
+	re      *Regexp
+	r       io.RuneReader // Nil for string and []byte inputs.
+	br      io.ByteReader // Reads r in raw mode.
+	bsrc    []byte        // Input if r == nil && isBytes.
+	src     string        // Input if r == nil && !isBytes.
+	saved   []int
+	occ     []int // Positions of prefilter.inner found so far.
+	arena   []int // Backing array of submatches, see newSub.
+	arena2  []int // Backing array newSub switches to when arena is full.
+	free    int   // Offset of the unused part of arena.
+	clist   *threadList
+	nlist   *threadList
+	rlists  [2]*threadList // Thread lists of reverseStart.
+	pos     int
+	sz      int
+	scan    int // PC of the find loop while the VM may skip input, -1 otherwise.
+	last    rune
+	c       rune
+	first   bool
+	closed  bool
+	idle    bool // No thread survived the last step.
+	isBytes bool
+	anchor  bool                   // Find looks for a match at the start of input only.
+	limit   *limit                 // If not nil, bounds the steps of find and match.
+	full    bool                   // Step keeps the threads of lower priority than a match.
+	reuse   bool                   // The result of find is copied out before the next find.
+	trim    func(*vm, *threadList) // If not nil, called by find before every step.
 }
 
 func newVM(re *Regexp, r io.RuneReader) *vm {
@@ -72,6 +97,89 @@ func newVM(re *Regexp, r io.RuneReader) *vm {
 		re: re,
 		r:  r,
 	}
+	if re.raw {
+		var ok bool
+		if vm.br, ok = r.(io.ByteReader); !ok {
+			vm.br = &runeBytes{r: r}
+		}
+	}
+	return vm.init()
+}
+
+// runeBytes reads the UTF-8 encoding of the runes read from r.
+type runeBytes struct {
+	r   io.RuneReader
+	buf [utf8.UTFMax]byte
+	i   int
+	n   int
+}
+
+func (b *runeBytes) ReadByte() (byte, error) {
+	if b.i == b.n {
+		r, _, err := b.r.ReadRune()
+		if err != nil {
+			return 0, err
+		}
+
+		b.i = 0
+		b.n = utf8.EncodeRune(b.buf[:], r)
+	}
+	b.i++
+	return b.buf[b.i-1], nil
+}
+
+// getVM returns a VM for b, if isBytes, or s, reusing the buffers of a VM
+// returned by put. The results of find are valid only until the next find or
+// put.
+func getVM(re *Regexp, b []byte, s string, isBytes bool) *vm {
+	p := vmPool.Get().(*vm)
+	*p = vm{
+		re:      re,
+		bsrc:    b,
+		src:     s,
+		occ:     p.occ[:0],
+		arena:   p.arena,
+		arena2:  p.arena2,
+		clist:   p.clist,
+		nlist:   p.nlist,
+		rlists:  p.rlists,
+		isBytes: isBytes,
+		reuse:   true,
+	}
+	return p.init()
+}
+
+// put returns vm to vmPool.
+func (vm *vm) put() {
+	vm.re = nil
+	vm.bsrc = nil
+	vm.src = ""
+	vm.saved = nil
+	vmPool.Put(vm)
+}
+
+func newBytesVM(re *Regexp, b []byte) *vm {
+	vm := &vm{
+		re:      re,
+		bsrc:    b,
+		isBytes: true,
+	}
+	return vm.init()
+}
+
+func newStringVM(re *Regexp, s string) *vm {
+	vm := &vm{
+		re:  re,
+		src: s,
+	}
+	return vm.init()
+}
+
+func (vm *vm) init() *vm {
+	vm.scan = -1
+	if vm.r == nil && vm.re.prefilter != nil {
+		vm.scan = vm.re.prog[vm.re.start1].out1
+	}
 	vm.c, vm.sz = vm.readRune()
 	vm.last = bot
 	vm.pos = 0
@@ -79,20 +187,230 @@ func newVM(re *Regexp, r io.RuneReader) *vm {
 	return vm
 }
 
-func (vm *vm) readRune() (rune, int) {
+// lists returns the two emptied thread lists of vm.
+func (vm *vm) lists() (*threadList, *threadList) {
+	if vm.clist == nil || len(vm.clist.dense) < len(vm.re.prog) {
+		vm.clist = newThreadList(len(vm.re.prog))
+		vm.nlist = newThreadList(len(vm.re.prog))
+	}
+	vm.clist.len, vm.clist.match = 0, false
+	vm.nlist.len, vm.nlist.match = 0, false
+	return vm.clist, vm.nlist
+}
+
+// newSub returns a slice for the submatches of a thread. The slices of a VM
+// from getVM are carved from arena, which find recycles. When arena is full,
+// the submatches of the threads in the thread lists and of the match found so
+// far move to arena2 and the arenas swap. The threads being added can still
+// refer to the old arena, so the new one must have room for more submatches
+// than a single addThread from step or find can allocate. The size of the
+// arenas is thus bounded by the size of the program.
+func (vm *vm) newSub() []int {
+	n := 2 * vm.re.groups
+	if !vm.reuse {
+		return make([]int, n)
+	}
+
+	if vm.free+n > len(vm.arena) {
+		vm.compact(n)
+	}
+	sub := vm.arena[vm.free : vm.free+n : vm.free+n]
+	vm.free += n
+	return sub
+}
+
+func (vm *vm) compact(n int) {
+	live := 1
+	for _, l := range []*threadList{vm.clist, vm.nlist} {
+		if l != nil {
+			live += l.len
+		}
+	}
+	size := 2 * (live + len(vm.re.prog) + 32) * n
+	if len(vm.arena2) < size {
+		vm.arena2 = make([]int, size)
+	}
+	vm.arena, vm.arena2 = vm.arena2, vm.arena
+	vm.free = 0
+	move := func(sub []int) []int {
+		if len(sub) == 0 {
+			return sub
+		}
+
+		r := vm.arena[vm.free : vm.free+n : vm.free+n]
+		copy(r, sub)
+		vm.free += n
+		return r
+	}
+	for _, l := range []*threadList{vm.clist, vm.nlist} {
+		if l != nil {
+			for i := 0; i < l.len; i++ {
+				l.dense[i].saved.sub = move(l.dense[i].saved.sub)
+			}
+		}
+	}
+	vm.saved = move(vm.saved)
+}
+
+func (vm *vm) readRune() (r rune, sz int) {
 	if vm.closed {
 		return pastEOF, 0
 	}
 
-	r, sz, err := vm.r.ReadRune()
-	if err != nil {
-		r = eof
-		sz = 0
-		vm.closed = true
+	switch {
+	case vm.br != nil:
+		c, err := vm.br.ReadByte()
+		if err != nil {
+			r = eof
+			vm.closed = true
+			break
+		}
+
+		r, sz = rune(c), 1
+	case vm.r != nil:
+		var err error
+		if r, sz, err = vm.r.ReadRune(); err != nil {
+			r = eof
+			sz = 0
+			vm.closed = true
+		}
+	case vm.isBytes:
+		switch {
+		case vm.pos >= len(vm.bsrc):
+			r = eof
+			vm.closed = true
+		case vm.bsrc[vm.pos] < utf8.RuneSelf || vm.re.raw:
+			r, sz = rune(vm.bsrc[vm.pos]), 1
+		default:
+			r, sz = utf8.DecodeRune(vm.bsrc[vm.pos:])
+		}
+	default:
+		switch {
+		case vm.pos >= len(vm.src):
+			r = eof
+			vm.closed = true
+		case vm.src[vm.pos] < utf8.RuneSelf || vm.re.raw:
+			r, sz = rune(vm.src[vm.pos]), 1
+		default:
+			r, sz = utf8.DecodeRuneInString(vm.src[vm.pos:])
+		}
 	}
 	return r, sz
 }
 
+// skip moves the VM forward to pos, which must be a rune boundary of a string
+// or []byte input.
+func (vm *vm) skip(pos int) {
+	if pos == vm.pos {
+		return
+	}
+
+	vm.pos = pos
+	vm.first = pos == 0
+	vm.last = bot
+	if pos != 0 {
+		vm.last, _ = vm.lastRune(pos)
+	}
+	vm.c, vm.sz = vm.readRune()
+}
+
+// lastRune decodes the rune of a string or []byte input ending at pos > 0.
+func (vm *vm) lastRune(pos int) (rune, int) {
+	switch {
+	case vm.isBytes:
+		if vm.re.raw {
+			return rune(vm.bsrc[pos-1]), 1
+		}
+
+		return utf8.DecodeLastRune(vm.bsrc[:pos])
+	default:
+		if vm.re.raw {
+			return rune(vm.src[pos-1]), 1
+		}
+
+		return utf8.DecodeLastRuneInString(vm.src[:pos])
+	}
+}
+
+// prefilter moves the VM to the next position where a match can start. It
+// returns false if there is no such position.
+func (vm *vm) prefilter() bool {
+	if vm.scan < 0 {
+		return true
+	}
+
+	pf := vm.re.prefilter
+	pos := vm.pos
+	if len(pf.inner) != 0 {
+		if len(vm.occ) == 0 {
+			for range pf.inner {
+				vm.occ = append(vm.occ, -1)
+			}
+		}
+		for i, v := range pf.inner {
+			if vm.occ[i] >= pos {
+				continue
+			}
+
+			var j int
+			switch {
+			case vm.isBytes:
+				j = bytes.Index(vm.bsrc[pos:], []byte(v))
+			default:
+				j = strings.Index(vm.src[pos:], v)
+			}
+			if j < 0 {
+				return false
+			}
+
+			vm.occ[i] = pos + j
+		}
+		if pf.window >= 0 {
+			if p := vm.occ[pf.window] - pf.before; p > pos {
+				for p > pos && !vm.runeStart(p) {
+					p--
+				}
+				pos = p
+			}
+		}
+	}
+	if len(pf.lits) != 0 {
+		var i int
+		switch {
+		case vm.isBytes:
+			i = pf.indexBytes(vm.bsrc[pos:])
+		default:
+			i = pf.index(vm.src[pos:])
+		}
+		if i < 0 {
+			return false
+		}
+
+		pos += i
+	}
+	vm.skip(pos)
+	return true
+}
+
+func (vm *vm) runeStart(pos int) bool {
+	switch {
+	case vm.re.raw:
+		return true
+	case vm.isBytes:
+		return utf8.RuneStart(vm.bsrc[pos])
+	}
+
+	return utf8.RuneStart(vm.src[pos])
+}
+
+// resume restarts the find loop in list after an idle step.
+func (vm *vm) resume(list *threadList) {
+	vm.idle = false
+	if vm.prefilter() {
+		vm.addThread(list, thread{pc: vm.re.start1}, vm.pos)
+	}
+}
+
 func (vm *vm) next() rune {
 	vm.last = vm.c
 	vm.pos += vm.sz
@@ -100,28 +418,202 @@ func (vm *vm) next() rune {
 	return vm.c
 }
 
+// fullMatch reports whether the whole input matches. It stops as soon as no
+// thread survives.
 func (vm *vm) fullMatch() bool {
-	a := vm.find()
-	return (vm.c == eof || vm.c == pastEOF) && a != nil && a[0] == 0 && a[1] == vm.pos
+	vm.full = true
+	clist, nlist := vm.lists()
+	vm.addThread(clist, thread{pc: vm.re.start}, vm.pos)
+	for vm.first = false; clist.len != 0; clist, nlist = nlist, clist {
+		switch {
+		case vm.c == pastEOF:
+			return clist.match
+		case vm.c == eof && clist.match:
+			return true
+		}
+
+		// At eof, the step completes the threads waiting for the end of
+		// text.
+		vm.step(clist, nlist)
+		vm.next()
+	}
+	return false
+}
+
+// partialMatch reports whether the input matches, in the sense of fullMatch
+// if anchored or match otherwise, and whether a match can consume text
+// appended to the input. Unless anchored, only matches starting before the end
+// of the input are considered for the latter.
+func (vm *vm) partialMatch(anchored bool) (full, prefix bool) {
+	vm.full = true
+	vm.scan = -1 // The prefilter does not know about appended text.
+	start := vm.re.start
+	if !anchored && !vm.re.anchored {
+		start = vm.re.start1
+	}
+	clist, nlist := vm.lists()
+	vm.addThread(clist, thread{pc: start}, vm.pos)
+	for vm.first = false; clist.len != 0; clist, nlist = nlist, clist {
+		switch vm.c {
+		case eof:
+			prefix = vm.consumes(clist, anchored)
+			full = full || clist.match
+		case pastEOF:
+			return full || clist.match, prefix
+		default:
+			full = full || !anchored && clist.match
+		}
+
+		vm.step(clist, nlist)
+		vm.next()
+	}
+	return full, prefix
+}
+
+// consumes reports whether a thread of l can reach an instruction consuming
+// input and, unless anchored, started before vm.pos. The assertions at the
+// end of the input that appended text can make hold are assumed to hold.
+func (vm *vm) consumes(l *threadList, anchored bool) bool {
+	var seen []bool
+	for i := 0; i < l.len; i++ {
+		t := &l.dense[i]
+		if sub := t.saved.sub; !anchored && (len(sub) == 0 || sub[0] < 0 || sub[0] >= vm.pos) {
+			continue
+		}
+
+		switch op := &vm.re.prog[t.pc]; op.kind {
+		case opChar, opCharClass, opDot, opDotNL, opNotCharClass:
+			return true
+		case opAssert:
+			if seen == nil {
+				seen = make([]bool, len(vm.re.prog))
+			}
+			if vm.open(op.arg) && vm.reachesConsumer(op.out, seen) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// open reports whether assertion a can hold at the end of the input once
+// text is appended to it.
+func (vm *vm) open(a int) bool {
+	switch a {
+	case assertBOT:
+		return vm.pos == 0
+	case assertEOT:
+		return false
+	default:
+		// The other assertions depend on the rune following the end of
+		// the input.
+		return true
+	}
+}
+
+// reachesConsumer reports whether an instruction consuming input is reachable
+// from pc without consuming input, assuming the open assertions hold.
+func (vm *vm) reachesConsumer(pc int, seen []bool) bool {
+	if seen[pc] {
+		return false
+	}
+
+	seen[pc] = true
+	switch op := &vm.re.prog[pc]; op.kind {
+	case opChar, opCharClass, opDot, opDotNL, opNotCharClass:
+		return true
+	case opAssert:
+		return vm.open(op.arg) && vm.reachesConsumer(op.out, seen)
+	case opNop, opSave:
+		return vm.reachesConsumer(op.out, seen)
+	case opSplit:
+		return vm.reachesConsumer(op.out, seen) || vm.reachesConsumer(op.out1, seen)
+	}
+	return false
 }
 
 func (vm *vm) match() bool {
-	clist := newThreadList(len(vm.re.prog))
-	nlist := newThreadList(len(vm.re.prog))
-	vm.addThread(clist, thread{pc: vm.re.start1}, 0)
+	start := vm.re.start1
+	switch {
+	case vm.re.bits != nil && vm.limit == nil:
+		return vm.bitMatch()
+	case vm.re.anchored:
+		if !vm.first {
+			return false
+		}
+
+		start = vm.re.start
+	case vm.re.reverse != nil && vm.r == nil && vm.limit == nil:
+		return vm.reverseStart() >= 0
+	case vm.byteMatch():
+		return vm.re.byteProg().match(vm.bsrc, vm.src)
+	case !vm.prefilter():
+		return false
+	}
+
+	clist, nlist := vm.lists()
+	vm.addThread(clist, thread{pc: start}, vm.pos)
 	for vm.first = false; !clist.match && clist.len != 0; clist, nlist = nlist, clist {
+		if vm.limit != nil && !vm.limit.step(vm) {
+			return false
+		}
+
 		vm.step(clist, nlist)
 		vm.next()
+		if vm.idle {
+			vm.resume(nlist)
+		}
 	}
 	return clist.match
 }
 
 func (vm *vm) find() []int {
-	clist := newThreadList(len(vm.re.prog))
-	nlist := newThreadList(len(vm.re.prog))
 	vm.saved = nil
-	vm.addThread(clist, thread{pc: vm.re.start1}, vm.pos)
+	if vm.reuse {
+		vm.free = 0
+	}
+	start := vm.re.start1
+	switch {
+	case vm.re.anchored || vm.anchor:
+		// Only the first search can succeed.
+		if !vm.first {
+			return nil
+		}
+
+		start = vm.re.start
+	case vm.re.reverse != nil && vm.r == nil && vm.limit == nil:
+		// The leftmost match starts where the longest reverse match
+		// from the end of the input ends.
+		i := vm.reverseStart()
+		if i < 0 {
+			return nil
+		}
+
+		vm.skip(i)
+		start = vm.re.start
+	case vm.re.bits != nil && vm.r == nil && vm.limit == nil:
+		// The bit parallel engine finds the end of the earliest match
+		// and skips the input no match can start in.
+		i := vm.bitStart()
+		if i < 0 {
+			return nil
+		}
+
+		vm.skip(i)
+	case !vm.prefilter():
+		return nil
+	}
+
+	clist, nlist := vm.lists()
+	vm.addThread(clist, thread{pc: start}, vm.pos)
 	for vm.first = false; clist.len != 0; clist, nlist = nlist, clist {
+		if vm.trim != nil {
+			vm.trim(vm, clist)
+		}
+		if vm.limit != nil && !vm.limit.step(vm) {
+			return nil
+		}
+
 		vm.step(clist, nlist)
 		if vm.c != eof && clist.match && !nlist.match {
 			break
@@ -133,15 +625,31 @@ func (vm *vm) find() []int {
 		}
 
 		vm.next()
+		if vm.idle {
+			vm.resume(nlist)
+		}
 	}
 	return vm.saved
 }
 
+// step advances the threads of clist over vm.c to nlist. Changes to step
+// must be mirrored in newCode, see code.go, and in genStep and genRuntime of
+// cmd/regexpgen/gen.go.
 func (vm *vm) step(clist *threadList, nlist *threadList) {
 	nlist.len = 0
 	nlist.match = false
+	if c := vm.re.code; c != nil {
+		for i := 0; i < clist.len && (!nlist.match || vm.full); i++ {
+			t := &clist.dense[i]
+			if f := c.step[t.pc]; f != nil {
+				f(vm, t, nlist)
+			}
+		}
+		return
+	}
+
 	for i := 0; i < clist.len; i++ {
-		if nlist.match {
+		if nlist.match && !vm.full {
 			break
 		}
 
@@ -169,6 +677,13 @@ func (vm *vm) step(clist *threadList, nlist *threadList) {
 				vm.addThread(nlist, thread{op.out, t.saved}, vm.pos+vm.sz)
 			}
 		case opDotNL:
+			if t.pc == vm.scan && nlist.len == 0 && vm.saved == nil {
+				// No match attempt is in progress, let the prefilter
+				// find where the next one can start.
+				vm.idle = true
+				break
+			}
+
 			if vm.c != eof {
 				vm.addThread(nlist, thread{op.out, t.saved}, vm.pos+vm.sz)
 			}
@@ -193,7 +708,15 @@ func (vm *vm) step(clist *threadList, nlist *threadList) {
 	}
 }
 
+// addThread adds t and the threads reachable from it without consuming input
+// to list. Changes to addThread must be mirrored in newCode, see code.go, and
+// in genAdd and genRuntime of cmd/regexpgen/gen.go.
 func (vm *vm) addThread(list *threadList, t thread, pos int) {
+	if c := vm.re.code; c != nil {
+		c.add[t.pc](vm, list, t, pos)
+		return
+	}
+
 	if list.has(t.pc) {
 		return
 	}
@@ -227,7 +750,7 @@ func (vm *vm) addThread(list *threadList, t thread, pos int) {
 	case opNotCharClass:
 		// nop
 	case opSave:
-		vm.addThread(list, thread{op.out, t.saved.update(op.arg, pos, 2*vm.re.groups)}, pos)
+		vm.addThread(list, thread{op.out, t.saved.update(vm, op.arg, pos)}, pos)
 	case opSplit:
 		vm.addThread(list, thread{op.out, t.saved}, pos)
 		vm.addThread(list, thread{op.out1, t.saved}, pos)
@@ -254,6 +777,40 @@ func (vm *vm) set(ranges []int) bool {
 	return false
 }
 
+// lowWater returns the leftmost position where a match found by the threads
+// in l or a later step can start.
+func (vm *vm) lowWater(l *threadList) int {
+	n := vm.pos
+	if vm.saved != nil && vm.saved[0] < n {
+		n = vm.saved[0]
+	}
+	for i := 0; i < l.len; i++ {
+		if sub := l.dense[i].saved.sub; len(sub) != 0 && sub[0] >= 0 && sub[0] < n {
+			n = sub[0]
+		}
+	}
+	return n
+}
+
+// inSet is like vm.set for ranges having no context dependent assertions.
+func inSet(ranges []int, c rune) bool {
+	for i := 0; i < len(ranges); i += 2 {
+		lo := ranges[i]
+		if lo < 0 {
+			if asserts[-lo](false, -1, c) {
+				return true
+			}
+
+			continue
+		}
+
+		if c >= rune(lo) && c <= rune(ranges[i+1]) {
+			return true
+		}
+	}
+	return false
+}
+
 const (
 	_ = iota // Values must be non-zero.
 	assertB